@@ -65,6 +65,13 @@ func (fs *Datastore) encode(key datastore.Key) (dir, file string) {
 	return dir, file
 }
 
+// Path returns the path of the file the value of key is stored in, whether
+// or not it exists.
+func (fs *Datastore) Path(key datastore.Key) string {
+	_, file := fs.encode(key)
+	return file
+}
+
 func (fs *Datastore) decode(file string) (key datastore.Key, ok bool) {
 	if path.Ext(file) != extension {
 		return datastore.Key{}, false
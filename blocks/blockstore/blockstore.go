@@ -6,6 +6,7 @@ import (
 	"errors"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/ipfs/go-datastore"
 	dsns "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/ipfs/go-datastore/namespace"
@@ -56,6 +57,23 @@ type GCBlockstore interface {
 	GCRequested() bool
 }
 
+// Ager is implemented by blockstores that know when each of their blocks was
+// stored.
+type Ager interface {
+	// StoredAt returns when the block k was stored.
+	StoredAt(k key.Key) (time.Time, error)
+}
+
+// WithAger returns bs as an Ager, that tells the age of its blocks with a.
+func WithAger(bs GCBlockstore, a Ager) GCBlockstore {
+	return &agingBlockstore{GCBlockstore: bs, Ager: a}
+}
+
+type agingBlockstore struct {
+	GCBlockstore
+	Ager
+}
+
 func NewBlockstore(d ds.Batching) *blockstore {
 	var dsb ds.Batching
 	dd := dsns.Wrap(d, BlockPrefix)
@@ -44,17 +44,33 @@ func Parse(input []string, stdin *os.File, root *cmds.Command) (cmds.Request, *c
 		}
 	}
 
-	// if '--hidden' is provided, enumerate hidden paths
-	hiddenOpt := req.Option("hidden")
+	// if '--hidden' or '--hidden-files-only' is provided, enumerate hidden
+	// paths; the latter leaves skipping hidden directories to the command
 	hidden := false
-	if hiddenOpt != nil {
-		hidden, _, err = hiddenOpt.Bool()
+	for _, name := range []string{"hidden", "hidden-files-only"} {
+		opt := req.Option(name)
+		if opt == nil {
+			continue
+		}
+		h, _, err := opt.Bool()
 		if err != nil {
 			return req, nil, nil, u.ErrCast()
 		}
+		hidden = hidden || h
 	}
 
-	stringArgs, fileArgs, err := parseArgs(stringVals, stdin, cmd.Arguments, recursive, hidden, root)
+	// with '--symlinks=follow', read what symlinks point to instead
+	follow := false
+	if opt := req.Option("symlinks"); opt != nil {
+		s, _, err := opt.String()
+		if err != nil {
+			return req, nil, nil, u.ErrCast()
+		}
+		follow = s == "follow"
+	}
+
+	opts := files.SerialOptions{Hidden: hidden, FollowSymlinks: follow}
+	stringArgs, fileArgs, err := parseArgs(stringVals, stdin, cmd.Arguments, recursive, opts, root)
 	if err != nil {
 		return req, cmd, path, err
 	}
@@ -233,7 +249,7 @@ func parseOpts(args []string, root *cmds.Command) (
 	return
 }
 
-func parseArgs(inputs []string, stdin *os.File, argDefs []cmds.Argument, recursive, hidden bool, root *cmds.Command) ([]string, []files.File, error) {
+func parseArgs(inputs []string, stdin *os.File, argDefs []cmds.Argument, recursive bool, opts files.SerialOptions, root *cmds.Command) ([]string, []files.File, error) {
 	// ignore stdin on Windows
 	if runtime.GOOS == "windows" {
 		stdin = nil
@@ -325,7 +341,7 @@ func parseArgs(inputs []string, stdin *os.File, argDefs []cmds.Argument, recursi
 				// treat stringArg values as file paths
 				fpath := inputs[0]
 				inputs = inputs[1:]
-				file, err := appendFile(fpath, argDef, recursive, hidden)
+				file, err := appendFile(fpath, argDef, recursive, opts)
 				if err != nil {
 					return nil, nil, err
 				}
@@ -406,7 +422,7 @@ func appendStdinAsString(args []string, stdin *os.File) ([]string, *os.File, err
 const notRecursiveFmtStr = "'%s' is a directory, use the '-%s' flag to specify directories"
 const dirNotSupportedFmtStr = "Invalid path '%s', argument '%s' does not support directories"
 
-func appendFile(fpath string, argDef *cmds.Argument, recursive, hidden bool) (files.File, error) {
+func appendFile(fpath string, argDef *cmds.Argument, recursive bool, opts files.SerialOptions) (files.File, error) {
 	fpath = filepath.ToSlash(filepath.Clean(fpath))
 
 	if fpath == "." {
@@ -422,7 +438,15 @@ func appendFile(fpath string, argDef *cmds.Argument, recursive, hidden bool) (fi
 		return nil, err
 	}
 
-	if stat.IsDir() {
+	isDir := stat.IsDir()
+	if opts.FollowSymlinks && stat.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Stat(fpath)
+		if err != nil {
+			return nil, err
+		}
+		isDir = target.IsDir()
+	}
+	if isDir {
 		if !argDef.Recursive {
 			return nil, fmt.Errorf(dirNotSupportedFmtStr, fpath, argDef.Name)
 		}
@@ -431,7 +455,7 @@ func appendFile(fpath string, argDef *cmds.Argument, recursive, hidden bool) (fi
 		}
 	}
 
-	return files.NewSerialFile(path.Base(fpath), fpath, hidden, stat)
+	return files.NewSerialFileWithOptions(path.Base(fpath), fpath, stat, opts)
 }
 
 // isTerminal returns true if stdin is a Stdin pipe (e.g. `cat file | ipfs`),
@@ -20,9 +20,54 @@ type serialFile struct {
 	stat              os.FileInfo
 	current           *File
 	handleHiddenFiles bool
+	follow            *follower // nil unless symlinks are followed
+}
+
+// SerialOptions tunes NewSerialFileWithOptions.
+type SerialOptions struct {
+	// Hidden includes the files and directories whose name starts with '.'.
+	Hidden bool
+
+	// FollowSymlinks reads what symlinks point to in their place. Symlinks
+	// pointing out of the file or directory given are not followed, but
+	// kept as symlinks, so that following them never leads out of it, and
+	// so are dangling ones. A symlink to a directory that contains it is an
+	// error.
+	FollowSymlinks bool
 }
 
 func NewSerialFile(name, path string, hidden bool, stat os.FileInfo) (File, error) {
+	return NewSerialFileWithOptions(name, path, stat, SerialOptions{Hidden: hidden})
+}
+
+// NewSerialFileWithOptions is like NewSerialFile, tuned by opts.
+func NewSerialFileWithOptions(name, path string, stat os.FileInfo, opts SerialOptions) (File, error) {
+	var follow *follower
+	if opts.FollowSymlinks {
+		root, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return nil, err
+		}
+		root, err = filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		follow = &follower{root: root}
+	}
+	return newSerialFile(name, path, opts.Hidden, stat, follow)
+}
+
+func newSerialFile(name, path string, hidden bool, stat os.FileInfo, follow *follower) (File, error) {
+	if follow != nil && stat.Mode()&os.ModeSymlink != 0 {
+		target, err := follow.resolve(path)
+		if err != nil {
+			return nil, err
+		}
+		if target != nil {
+			stat = target
+		}
+	}
+
 	switch mode := stat.Mode(); {
 	case mode.IsRegular():
 		file, err := os.Open(path)
@@ -37,7 +82,12 @@ func NewSerialFile(name, path string, hidden bool, stat os.FileInfo) (File, erro
 		if err != nil {
 			return nil, err
 		}
-		return &serialFile{name, path, contents, stat, nil, hidden}, nil
+		if follow != nil {
+			if follow, err = follow.enter(path, stat); err != nil {
+				return nil, err
+			}
+		}
+		return &serialFile{name, path, contents, stat, nil, hidden, follow}, nil
 	case mode&os.ModeSymlink != 0:
 		target, err := os.Readlink(path)
 		if err != nil {
@@ -86,7 +136,7 @@ func (f *serialFile) NextFile() (File, error) {
 	// recursively call the constructor on the next file
 	// if it's a regular file, we will open it as a ReaderFile
 	// if it's a directory, files in it will be opened serially
-	sf, err := NewSerialFile(fileName, filePath, f.handleHiddenFiles, stat)
+	sf, err := newSerialFile(fileName, filePath, f.handleHiddenFiles, stat, f.follow)
 	if err != nil {
 		return nil, err
 	}
@@ -116,6 +166,7 @@ func (f *serialFile) Close() error {
 		if err != nil && err != syscall.EINVAL {
 			return err
 		}
+		f.current = nil
 	}
 
 	return nil
@@ -139,3 +190,44 @@ func (f *serialFile) Size() (int64, error) {
 	})
 	return du, err
 }
+
+// follower tracks what is needed to follow symlinks under root: the
+// directories entered so far, to detect cycles.
+type follower struct {
+	root    string
+	parents []os.FileInfo
+}
+
+// resolve returns the stat of what the symlink at path points to, or nil if
+// it points out of the root or to nothing, and is to be kept as a symlink.
+func (f *follower) resolve(path string) (os.FileInfo, error) {
+	target, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	target, err = filepath.Abs(target)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(f.root, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, nil
+	}
+	return os.Stat(target)
+}
+
+// enter returns the follower for the contents of the directory at path, or
+// an error if that directory is one of those it is in.
+func (f *follower) enter(path string, stat os.FileInfo) (*follower, error) {
+	for _, p := range f.parents {
+		if os.SameFile(p, stat) {
+			return nil, fmt.Errorf("cannot follow symlink %s: it leads to a directory containing it", path)
+		}
+	}
+	parents := make([]os.FileInfo, len(f.parents), len(f.parents)+1)
+	copy(parents, f.parents)
+	return &follower{root: f.root, parents: append(parents, stat)}, nil
+}
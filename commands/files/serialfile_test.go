@@ -0,0 +1,152 @@
+package files
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// listFiles walks f, describing each file under it by its name: "dir",
+// "file", or "link:" followed by the symlink's target.
+func listFiles(t *testing.T, f File, out map[string]string) {
+	for {
+		child, err := f.NextFile()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch c := child.(type) {
+		case *Symlink:
+			out[c.FileName()] = "link:" + c.Target
+		default:
+			if c.IsDirectory() {
+				out[c.FileName()] = "dir"
+				listFiles(t, c, out)
+			} else {
+				out[c.FileName()] = "file"
+			}
+		}
+	}
+}
+
+func serialTestTree(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks are not supported")
+	}
+	dir, err := ioutil.TempDir("", "serialfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{"outside", "root/data"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"outside/secret", "root/data/a"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"root/inlink":   "data",
+		"root/outlink":  "../outside",
+		"root/dangling": "missing",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSerialFileSymlinks(t *testing.T) {
+	dir := serialTestTree(t)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	stat, err := os.Lstat(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		follow   bool
+		expected map[string]string
+	}{
+		{false, map[string]string{
+			"root/data":     "dir",
+			"root/data/a":   "file",
+			"root/inlink":   "link:data",
+			"root/outlink":  "link:../outside",
+			"root/dangling": "link:missing",
+		}},
+		// the link out of root, and the one to nothing, are kept as links
+		{true, map[string]string{
+			"root/data":     "dir",
+			"root/data/a":   "file",
+			"root/inlink":   "dir",
+			"root/inlink/a": "file",
+			"root/outlink":  "link:../outside",
+			"root/dangling": "link:missing",
+		}},
+	}
+	for _, c := range cases {
+		f, err := NewSerialFileWithOptions("root", root, stat, SerialOptions{FollowSymlinks: c.follow})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]string)
+		listFiles(t, f, got)
+		if len(got) != len(c.expected) {
+			t.Fatalf("follow=%t: expected %v, got %v", c.follow, c.expected, got)
+		}
+		for name, kind := range c.expected {
+			if got[name] != kind {
+				t.Fatalf("follow=%t: expected %s to be %s, got %q", c.follow, name, kind, got[name])
+			}
+		}
+	}
+}
+
+func TestSerialFileSymlinkCycle(t *testing.T) {
+	dir := serialTestTree(t)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	if err := os.Symlink("..", filepath.Join(root, "data", "up")); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Lstat(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewSerialFileWithOptions("root", root, stat, SerialOptions{FollowSymlinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var walk func(f File) error
+	walk = func(f File) error {
+		for {
+			child, err := f.NextFile()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if child.IsDirectory() {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := walk(f); err == nil {
+		t.Fatal("expected an error following a symlink to a parent directory")
+	}
+}
@@ -14,8 +14,10 @@ import (
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	path "github.com/ipfs/go-ipfs/path"
 	pin "github.com/ipfs/go-ipfs/pin"
+	gc "github.com/ipfs/go-ipfs/pin/gc"
 	repo "github.com/ipfs/go-ipfs/repo"
 	cfg "github.com/ipfs/go-ipfs/repo/config"
+	iolimit "github.com/ipfs/go-ipfs/thirdparty/iolimit"
 	ci "gx/ipfs/QmNefBbWHR9JEiP3KDVqZsBLQVRmH3GBG2D2Ke24SsFqfW/go-libp2p/p2p/crypto"
 	peer "gx/ipfs/QmNefBbWHR9JEiP3KDVqZsBLQVRmH3GBG2D2Ke24SsFqfW/go-libp2p/p2p/peer"
 	goprocessctx "gx/ipfs/QmQopLATEYMNg7dVqZRNDfeE2S1yKy8zrRh5xnYiuqeZBn/goprocess/context"
@@ -133,6 +135,11 @@ func setupNode(ctx context.Context, n *IpfsNode, cfg *BuildCfg) error {
 	if err != nil {
 		return err
 	}
+	if ar, ok := n.Repo.(repo.AgingRepo); ok {
+		if a := ar.BlockAger(); a != nil {
+			n.Blockstore = bstore.WithAger(n.Blockstore, a)
+		}
+	}
 
 	if cfg.Online {
 		rcfg, err := n.Repo.Config()
@@ -147,6 +154,13 @@ func setupNode(ctx context.Context, n *IpfsNode, cfg *BuildCfg) error {
 		n.Exchange = offline.Exchange(n.Blockstore)
 	}
 
+	rcfg, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
+	n.IOLimit = iolimit.New(rcfg.Datastore.MaxIOConcurrency)
+	n.GCLock = gc.NewLock()
+
 	n.Blocks = bserv.New(n.Blockstore, n.Exchange)
 	n.DAG = dag.NewDAGService(n.Blocks)
 	n.Pinning, err = pin.LoadPinner(n.Repo.Datastore(), n.DAG)
@@ -1,33 +1,106 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	gopath "path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/cheggaaa/pb"
+	humanize "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/dustin/go-humanize"
 	"github.com/ipfs/go-ipfs/core/coreunix"
 
+	key "github.com/ipfs/go-ipfs/blocks/key"
 	cmds "github.com/ipfs/go-ipfs/commands"
 	files "github.com/ipfs/go-ipfs/commands/files"
 	core "github.com/ipfs/go-ipfs/core"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	mfs "github.com/ipfs/go-ipfs/mfs"
+	path "github.com/ipfs/go-ipfs/path"
 	u "gx/ipfs/QmZNVWh8LLjAavuQ2JXuFmuYH3C11xo988vSgp7UQrTRj1/go-ipfs-util"
+	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
 )
 
 // Error indicating the max depth has been exceded.
-var ErrDepthLimitExceeded = fmt.Errorf("depth limit exceeded")
+var ErrDepthLimitExceeded = coreunix.ErrDepthLimitExceeded
 
 const (
-	quietOptionName    = "quiet"
-	silentOptionName   = "silent"
-	progressOptionName = "progress"
-	trickleOptionName  = "trickle"
-	wrapOptionName     = "wrap-with-directory"
-	hiddenOptionName   = "hidden"
-	onlyHashOptionName = "only-hash"
-	chunkerOptionName  = "chunker"
-	pinOptionName      = "pin"
+	quietOptionName      = "quiet"
+	silentOptionName     = "silent"
+	progressOptionName   = "progress"
+	trickleOptionName    = "trickle"
+	wrapOptionName       = "wrap-with-directory"
+	hiddenOptionName     = "hidden"
+	dotFilesOptionName   = "hidden-files-only"
+	onlyHashOptionName   = "only-hash"
+	chunkerOptionName    = "chunker"
+	pinOptionName        = "pin"
+	rawLeavesOptionName  = "raw-leaves"
+	rawLeafMaxOptionName = "raw-leaf-max"
+	sizeOptionName       = "size"
+	verifyOptionName     = "verify"
+	includeOptionName    = "include"
+	excludeOptionName    = "exclude"
+	rejectTypeOptName    = "reject-type"
+	renameDupsOptionName = "rename-duplicates"
+	normalizeOptionName  = "normalize-names"
+	manifestOptionName   = "manifest"
+	flushOptionName      = "flush"
+	localOptionName      = "local"
+	maxLinksOptionName   = "max-links"
+	journalOptionName    = "journal"
+	recursivePinOptName  = "recursive-pin"
+	statsOptionName      = "stats"
+	preservePathOptName  = "preserve-path"
+	toMFSOptionName      = "to-mfs"
+	forceOptionName      = "force"
+	checksumOptionName   = "checksum"
+	sumAlgOptionName     = "checksum-alg"
+	verboseOptionName    = "verbose"
+	metadataOptionName   = "metadata"
+	maxEntriesOptName    = "max-dir-entries"
+	provideOptionName    = "provide"
+	writeRetriesOptName  = "write-retries"
+	sessionDigestOptName = "session-digest"
+	transformOptionName  = "transform"
+	maxDepthOptionName   = "max-depth"
+	checkOptionName      = "check"
+	coverOptionName      = "cover"
+	coverNameOptName     = "cover-name"
+	concatOptionName     = "concat"
+	preChunkedOptName    = "pre-chunked"
+	maxTotalOptionName   = "max-total"
+	readerBufOptionName  = "reader-buffer"
+	unixfsTypeOptionName = "unixfs-type"
+	postAddExecOptName   = "post-add-exec"
+	postAddFatalOptName  = "post-add-fatal"
+	pinRulesOptionName   = "pin-rules"
+	proofOptionName      = "proof-for-offset"
+	appendToOptionName   = "append-to"
+	splitOptionName      = "split"
+	exportOptionName     = "export"
+	onTruncateOptName    = "on-truncate"
+	expectOptionName     = "expect"
+	outBufOptionName     = "output-buffer"
+	nameIndexOptionName  = "name-index"
+	manifestOutOptName   = "manifest-out"
+	symlinksOptionName   = "symlinks"
+	offsetIdxOptionName  = "offset-index"
+	showBlocksOptName    = "show-blocks"
+	maxBlocksOptName     = "max-blocks"
+	recWorkersOptName    = "recursive-workers"
 )
 
+// provideTimeout bounds each announcement made for --provide.
+const provideTimeout = time.Minute
+
 var AddCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Add a file to ipfs.",
@@ -35,6 +108,99 @@ var AddCmd = &cmds.Command{
 Adds contents of <path> to ipfs. Use -r to add directories.
 Note that directories are added recursively, to form the ipfs
 MerkleDAG.
+`,
+		LongDescription: `
+Adds contents of <path> to ipfs. Use -r to add directories.
+Note that directories are added recursively, to form the ipfs
+MerkleDAG.
+
+With --metadata, the given JSON object is stored as a file next to the
+added content, and both are wrapped in a directory. That directory is
+the root of the add, reported last and pinned:
+
+	<root>/data    the added file or directory
+	<root>/meta    the metadata JSON, as a file
+
+With --cover, the given file, such as a thumbnail, is added and linked
+in the same wrapping directory, as 'cover' or the name given with
+--cover-name. The file is read on the node doing the add, which is the
+daemon's when one is running:
+
+	<root>/cover   the preview file
+
+With --offset-index, the added file is linked in the same wrapping
+directory along with an index of the blocks holding its data, so that a
+reader can seek to any offset without walking the file's intermediate
+nodes. The index is a text file with a line '<offset> <size> <hash>' for
+each block holding data, in the order of that data in the file:
+
+	<root>/offsets the offset index
+
+With --concat, the given files are not added one by one. Their contents,
+one after another, are added as a single file instead, whose hash is the
+one of the concatenated bytes.
+
+With --split, each file is added as a directory of files holding the
+given number of its bytes each, so that every part can be fetched on its
+own. A 'manifest' JSON file in the directory gives the size of the file
+and the names of the parts, in order:
+
+	<root>/part-000000
+	<root>/part-000001
+	<root>/manifest
+
+With --pin-rules, each added file is pinned as the first matching rule of
+the given file says, instead of pinning the root. Each line of that file
+is a glob pattern, matched against the path of the file below the added
+directory, and a mode, separated by a tab:
+
+	**/*.iso	none
+	docs/**	recursive
+	**	direct
+
+The mode is recursive, direct or none, the default for files no rule
+matches. Directories are not pinned. The mode chosen for each file is
+reported after its name.
+
+With --proof-for-offset, the Merkle path from the added file's root to
+the block holding the byte at the given offset is printed after the
+root, root first. It lets a third party check that block against the
+root without fetching the rest of the file.
+
+With --append-to, the added data is appended to the end of an existing
+file, which must have the trickle layout ('ipfs add -t'). The new file
+shares all of the existing file's blocks, but those along its right edge
+and its root, so growing a log does not mean adding it all over again.
+
+With --show-blocks, the hash of every block of the added DAG is printed
+after the root, root first and each block once, so that a peer can be
+told to fetch exactly those blocks. --max-blocks caps the list.
+
+With --manifest-out, a line is written for each added file to the given
+file, listing the blocks holding the file's data in the order of its
+bytes, each with the number of bytes it holds:
+
+	<root hash><TAB><name><TAB><hash>:<size> <hash>:<size> ...
+
+Fetching the listed blocks one after another streams the whole file.
+
+With --pre-chunked, the given directory holds the chunks of a single
+file, split by another tool, such as one aware of the file's format. Each
+file of the directory is one chunk, and the chunks follow each other in
+the order of their names. The file is built over them with the chosen
+layout, and gets the same hash as if ipfs had split it there:
+
+	ipfs add -r --pre-chunked chunks/
+
+With --expect, the add fails if its root, the last hash reported, is not
+the given one, and nothing is pinned. Together with --only-hash, this
+checks that some content still hashes to a known root without storing it.
+
+Symlinks, including those to directories, are added as symlinks. With
+--symlinks=follow, what they point to is added in their place, but a
+symlink pointing out of the added path is still added as a symlink, so
+that the add never leads out of it. A symlink to a directory containing
+it is an error.
 `,
 	},
 
@@ -45,15 +211,116 @@ MerkleDAG.
 		cmds.OptionRecursivePath, // a builtin option that allows recursive paths (-r, --recursive)
 		cmds.BoolOption(quietOptionName, "q", "Write minimal output."),
 		cmds.BoolOption(silentOptionName, "Write no output."),
+		cmds.BoolOption(verboseOptionName, "v", "Also write the number of data blocks and the DAG depth of each added file."),
 		cmds.BoolOption(progressOptionName, "p", "Stream progress data."),
 		cmds.BoolOption(trickleOptionName, "t", "Use trickle-dag format for dag generation."),
 		cmds.BoolOption(onlyHashOptionName, "n", "Only chunk and hash - do not write to disk."),
+		cmds.BoolOption(checkOptionName, "Only chunk and hash, and report whether each file is already fully stored locally. Nothing is written or pinned."),
 		cmds.BoolOption(wrapOptionName, "w", "Wrap files with a directory object."),
+		cmds.BoolOption(preservePathOptName, "With -w, nest inputs in the wrapper under their path as given, rather than their base name."),
 		cmds.BoolOption(hiddenOptionName, "H", "Include files that are hidden. Only takes effect on recursive add."),
+		cmds.BoolOption(dotFilesOptionName, "Include hidden files, but not hidden directories. Only takes effect on recursive add."),
+		cmds.StringOption(symlinksOptionName, "How to add symlinks, including symlinks to directories: 'store' adds them as symlinks, 'follow' adds what they point to, unless that is out of the added path or missing. Default: store."),
 		cmds.StringOption(chunkerOptionName, "s", "Chunking algorithm to use."),
 		cmds.BoolOption(pinOptionName, "Pin this object when adding.  Default: true."),
+		cmds.BoolOption(recursivePinOptName, "Pin the added DAG recursively. With false, only the root is pinned, directly. Default: true."),
+		cmds.IntOption(maxEntriesOptName, fmt.Sprintf("Fail if a directory has more than this many entries. Default: %d.", coreunix.DefaultMaxDirEntries)),
+		cmds.IntOption(splitOptionName, "Add each file as a directory of separately addressed parts of this many bytes, with a manifest of their order."),
+		cmds.IntOption(maxTotalOptionName, "Fail, without pinning anything, once more than this many bytes were read from all the files added. Default: unlimited."),
+		cmds.IntOption(maxDepthOptionName, "Fail if a directory is nested more than this many levels deep, counting the added directory as 1. Default: unlimited."),
+		cmds.IntOption(maxLinksOptionName, "Maximum number of links per intermediate node of a file, at least 2. Changes the resulting hash."),
+		cmds.StringOption(transformOptionName, "Transform the contents of files as they are added: 'crlf-to-lf' turns CRLF line endings into LF. This changes the resulting hashes."),
+		cmds.BoolOption(rawLeavesOptionName, "Store leaf data as raw blocks."),
+		cmds.IntOption(rawLeafMaxOptionName, "Only store leaves of at most this many bytes as raw blocks. Requires --raw-leaves."),
+		cmds.StringOption(unixfsTypeOptionName, "Unixfs type of the leaves of added files, and of files of a single block: 'file' or 'raw'. Default: as the layout chooses."),
+		cmds.StringOption(onTruncateOptName, "What to do with a file that shrinks or can no longer be read while it is added, such as a rotated log: 'error' fails the add, 'skip' leaves the file out, 'partial' adds the bytes read. Default: add what was read without checking."),
+		cmds.IntOption(readerBufOptionName, "Read files through a buffer of this many bytes, for fewer, larger reads from slow or network filesystems. Default: unbuffered."),
+		cmds.IntOption(recWorkersOptName, "Number of files to hash at once, across the added directories. Only applies to local files, when adding without a daemon. Files are still reported in the order they are found. Default: 1."),
+		cmds.IntOption(outBufOptionName, "Number of output objects to hold for a slow client, beyond which the add waits for it. Progress updates beyond it are merged instead, keeping the latest of each file. Default: 8."),
+		cmds.IntOption(sizeOptionName, "Total size of the input in bytes, used for progress when it can't be determined (e.g. stdin)."),
+		cmds.IntOption(writeRetriesOptName, "Retry failed block writes this many times, with backoff. Errors such as a full disk are not retried. Default: 0."),
+		cmds.BoolOption(verifyOptionName, "Read back every added block from the blockstore before reporting success."),
+		cmds.StringOption(includeOptionName, "Comma-separated glob patterns of files to add, relative to the added directory. '**' matches any number of directories."),
+		cmds.StringOption(excludeOptionName, "Comma-separated glob patterns of files and directories to skip, relative to the added directory."),
+		cmds.StringOption(rejectTypeOptName, "Comma-separated content type patterns, such as 'image/*' or 'application/octet-stream'. Fail the add if a file's type, detected from its first 512 bytes, matches one."),
+		cmds.BoolOption(renameDupsOptionName, "Add a numeric suffix to duplicate top-level names instead of failing."),
+		cmds.BoolOption(normalizeOptionName, "Treat backslashes in the names of added files as directory separators, so that a tree added on Windows gives the same root as on other platforms."),
+		cmds.BoolOption(flushOptionName, "Flush pins to disk before returning. With false, pins are only durable after 'ipfs repo flush' or daemon shutdown. Default: true."),
+		cmds.StringOption(provideOptionName, "Announce the added content to the routing system right away, in the background: 'root' for the root only, 'all' for every block. Requires the daemon."),
+		cmds.BoolOption(localOptionName, "Do not touch the network, even on an online node: added blocks are stored but not announced or provided."),
+		cmds.BoolOption(checksumOptionName, "Report a plain digest of each added file's contents. Directories get none."),
+		cmds.StringOption(sumAlgOptionName, "Checksum algorithm for --checksum: sha1, sha256 or sha512. Default: sha256."),
+		cmds.StringOption(pinRulesOptionName, "Pin each file as the first matching '<pattern><TAB><mode>' line of this file (a path on the node doing the add) says, instead of pinning the root. Mode is recursive, direct or none."),
+		cmds.StringOption(exportOptionName, "Write all blocks of the added DAG to this file (a path on the node doing the add), each as '<length><multihash><data>', children first and the root last. Nothing is written with --only-hash."),
+		cmds.StringOption(nameIndexOptionName, "Write a '<hash><TAB><name>' line for each added file to this file (a path on the node doing the add), replacing it once the add succeeded."),
+		cmds.StringOption(manifestOutOptName, "Write a line listing the blocks holding the data of each added file, in order and with their sizes, to this file (a path on the node doing the add), replacing it once the add succeeded."),
+		cmds.StringOption(journalOptionName, "Record completed files in this file (a path on the node doing the add), and skip the files it lists when re-running an interrupted add with the same arguments."),
+		cmds.StringOption(sessionDigestOptName, "Print a digest of the names and hashes of all added objects, in the order they were added, with this algorithm: sha1, sha256 or sha512."),
+		cmds.BoolOption(statsOptionName, "Print the number and total size of the added files, with a histogram of their sizes, and how many of the blocks written were already stored."),
+		cmds.StringOption(toMFSOptionName, "Link the added root into the files API (mfs) at this path, creating parent directories."),
+		cmds.BoolOption(forceOptionName, "With --to-mfs, replace an existing entry at the path."),
+		cmds.StringOption(metadataOptionName, "A JSON object (e.g. title, author, tags) to store as the 'meta' link of a directory wrapping the added root, linked as 'data'."),
+		cmds.StringOption(coverOptionName, "Store this file (a path on the node doing the add), a preview such as a thumbnail, as a link of a directory wrapping the added root, linked as 'data'."),
+		cmds.BoolOption(offsetIdxOptionName, "Store an index of the blocks of the added file by offset next to it, in a directory wrapping the added root, linked as 'data'. Only for a single file."),
+		cmds.StringOption(coverNameOptName, "Link name of the --cover file. Default: 'cover'."),
+		cmds.BoolOption(preChunkedOptName, "Add a single file from a directory of chunks split by another tool, each file of it one chunk, in the order of their names. Use with -r."),
+		cmds.BoolOption(concatOptionName, "Add the contents of all the given files, one after another, as a single file. Its hash differs from those of the files added separately."),
+		cmds.StringOption(appendToOptionName, "Append the added data to the end of this file, a hash or path, which must use the trickle layout. Implies --trickle."),
+		cmds.IntOption(proofOptionName, "Also print the hashes of the nodes from the root of the added file down to the block holding the byte at this offset."),
+		cmds.BoolOption(showBlocksOptName, "Also print the hash of every block of the added DAG, root first, once the add succeeded."),
+		cmds.IntOption(maxBlocksOptName, "Print at most this many blocks with --show-blocks. Default: all."),
+		cmds.StringOption(expectOptionName, "Fail unless the root of the add is this hash. Nothing is pinned if it is not."),
+		cmds.BoolOption(manifestOptionName, "Treat the input as a manifest of '<path><TAB><hash>' lines and build a directory linking to those objects."),
+		cmds.StringOption(postAddExecOptName, "Command to run once the add succeeded, with the root's hash and name appended as arguments, and set as $IPFS_ADD_HASH and $IPFS_ADD_NAME. It is split into arguments at whitespace, with no quoting, and run without a shell; wrap it in a script to pass arguments with spaces."),
+		cmds.BoolOption(postAddFatalOptName, "Fail the add if the --post-add-exec command fails. Default: only report it."),
 	},
 	PreRun: func(req cmds.Request) error {
+		sizeHint, sizeHintFound, err := req.Option(sizeOptionName).Int()
+		if err != nil {
+			return err
+		}
+		if sizeHintFound && sizeHint < 0 {
+			return fmt.Errorf("--%s must not be negative", sizeOptionName)
+		}
+
+		// the files were read as this says while parsing the arguments
+		symlinks, _, _ := req.Option(symlinksOptionName).String()
+		if symlinks != "" && symlinks != "store" && symlinks != "follow" {
+			return fmt.Errorf("--%s must be 'store' or 'follow'", symlinksOptionName)
+		}
+
+		if preserve, _, _ := req.Option(preservePathOptName).Bool(); preserve {
+			if wrap, _, _ := req.Option(wrapOptionName).Bool(); !wrap {
+				return fmt.Errorf("--%s requires --%s", preservePathOptName, wrapOptionName)
+			}
+			hidden, _, _ := req.Option(hiddenOptionName).Bool()
+			dotFiles, _, _ := req.Option(dotFilesOptionName).Bool()
+			opts := files.SerialOptions{Hidden: hidden || dotFiles, FollowSymlinks: symlinks == "follow"}
+			f, err := preservePaths(req.Files(), opts)
+			if err != nil {
+				return err
+			}
+			req.SetFiles(f)
+		}
+
+		if alg, found, _ := req.Option(sessionDigestOptName).String(); found {
+			if silent, _, _ := req.Option(silentOptionName).Bool(); silent {
+				return fmt.Errorf("--%s cannot be used with --%s", sessionDigestOptName, silentOptionName)
+			}
+			if _, err := coreunix.NewChecksum(alg); err != nil {
+				return err
+			}
+		}
+
+		if hook, found, _ := req.Option(postAddExecOptName).String(); found {
+			if silent, _, _ := req.Option(silentOptionName).Bool(); silent {
+				return fmt.Errorf("--%s cannot be used with --%s", postAddExecOptName, silentOptionName)
+			}
+			if len(strings.Fields(hook)) == 0 {
+				return fmt.Errorf("--%s must not be empty", postAddExecOptName)
+			}
+		}
+
 		if quiet, _, _ := req.Option(quietOptionName).Bool(); quiet {
 			return nil
 		}
@@ -66,19 +333,31 @@ MerkleDAG.
 
 		req.SetOption(progressOptionName, progress)
 
+		sizeCh := make(chan int64, 1)
+
 		sizeFile, ok := req.Files().(files.SizeFile)
 		if !ok {
+			if sizeHintFound {
+				req.Values()["size"] = sizeCh
+				sizeCh <- int64(sizeHint)
+				return nil
+			}
+
 			// we don't need to error, the progress bar just won't know how big the files are
 			log.Warning("cannnot determine size of input file")
 			return nil
 		}
 
-		sizeCh := make(chan int64, 1)
 		req.Values()["size"] = sizeCh
 
 		go func() {
 			size, err := sizeFile.Size()
 			if err != nil {
+				if sizeHintFound {
+					sizeCh <- int64(sizeHint)
+					return
+				}
+
 				log.Warningf("error getting files size: %s", err)
 				// see comment above
 				return
@@ -109,13 +388,407 @@ MerkleDAG.
 		wrap, _, _ := req.Option(wrapOptionName).Bool()
 		hash, _, _ := req.Option(onlyHashOptionName).Bool()
 		hidden, _, _ := req.Option(hiddenOptionName).Bool()
+		hiddenFiles, _, _ := req.Option(dotFilesOptionName).Bool()
 		silent, _, _ := req.Option(silentOptionName).Bool()
 		chunker, _, _ := req.Option(chunkerOptionName).String()
 		dopin, pin_found, _ := req.Option(pinOptionName).Bool()
+		recursive, recursiveFound, _ := req.Option(recursivePinOptName).Bool()
+		rawLeaves, _, _ := req.Option(rawLeavesOptionName).Bool()
+		rawLeafMax, rawLeafMaxFound, _ := req.Option(rawLeafMaxOptionName).Int()
+		unixfsType, _, _ := req.Option(unixfsTypeOptionName).String()
+		verify, _, _ := req.Option(verifyOptionName).Bool()
+		include, _, _ := req.Option(includeOptionName).String()
+		exclude, _, _ := req.Option(excludeOptionName).String()
+		rejectType, _, _ := req.Option(rejectTypeOptName).String()
+		renameDups, _, _ := req.Option(renameDupsOptionName).Bool()
+		normalize, _, _ := req.Option(normalizeOptionName).Bool()
+		manifest, _, _ := req.Option(manifestOptionName).Bool()
+		flush, flushFound, _ := req.Option(flushOptionName).Bool()
+		local, _, _ := req.Option(localOptionName).Bool()
+		maxLinks, maxLinksFound, _ := req.Option(maxLinksOptionName).Int()
+		maxEntries, maxEntriesFound, _ := req.Option(maxEntriesOptName).Int()
+		writeRetries, _, _ := req.Option(writeRetriesOptName).Int()
+		maxDepth, maxDepthFound, _ := req.Option(maxDepthOptionName).Int()
+		maxTotal, maxTotalFound, _ := req.Option(maxTotalOptionName).Int()
+		split, splitFound, _ := req.Option(splitOptionName).Int()
+		readerBuf, readerBufFound, _ := req.Option(readerBufOptionName).Int()
+		workers, workersFound, _ := req.Option(recWorkersOptName).Int()
+		onTruncate, onTruncateFound, _ := req.Option(onTruncateOptName).String()
+		transform, _, _ := req.Option(transformOptionName).String()
+		journalPath, _, _ := req.Option(journalOptionName).String()
+		nameIndexPath, _, _ := req.Option(nameIndexOptionName).String()
+		manifestOut, _, _ := req.Option(manifestOutOptName).String()
+		pinRulesPath, _, _ := req.Option(pinRulesOptionName).String()
+		proofOffset, proofFound, _ := req.Option(proofOptionName).Int()
+		showBlocks, _, _ := req.Option(showBlocksOptName).Bool()
+		maxBlocks, maxBlocksFound, _ := req.Option(maxBlocksOptName).Int()
+		appendTo, _, _ := req.Option(appendToOptionName).String()
+		exportPath, _, _ := req.Option(exportOptionName).String()
+		stats, _, _ := req.Option(statsOptionName).Bool()
+		toMFS, _, _ := req.Option(toMFSOptionName).String()
+		force, _, _ := req.Option(forceOptionName).Bool()
+		checksum, _, _ := req.Option(checksumOptionName).Bool()
+		checksumAlg, checksumAlgFound, _ := req.Option(sumAlgOptionName).String()
+		metadata, metadataFound, _ := req.Option(metadataOptionName).String()
+		provide, _, _ := req.Option(provideOptionName).String()
+		check, _, _ := req.Option(checkOptionName).Bool()
+		coverPath, coverFound, _ := req.Option(coverOptionName).String()
+		offsetIndex, _, _ := req.Option(offsetIdxOptionName).Bool()
+		coverName, coverNameFound, _ := req.Option(coverNameOptName).String()
+		concat, _, _ := req.Option(concatOptionName).Bool()
+		preChunked, _, _ := req.Option(preChunkedOptName).Bool()
+		expect, expectFound, _ := req.Option(expectOptionName).String()
+		outBuf, outBufFound, _ := req.Option(outBufOptionName).Int()
 
 		if !pin_found { // default
 			dopin = true
 		}
+		if !recursiveFound {
+			recursive = true
+		}
+		if !flushFound {
+			flush = true
+		}
+		if !flush && dopin {
+			log.Warning("add: pin flush deferred, pins are not durable until 'ipfs repo flush' or shutdown")
+		}
+
+		if hidden && hiddenFiles {
+			res.SetError(fmt.Errorf("--%s and --%s are mutually exclusive", hiddenOptionName, dotFilesOptionName), cmds.ErrClient)
+			return
+		}
+
+		if checksumAlgFound && !checksum {
+			res.SetError(fmt.Errorf("--%s requires --%s", sumAlgOptionName, checksumOptionName), cmds.ErrClient)
+			return
+		}
+		if checksum {
+			if !checksumAlgFound {
+				checksumAlg = coreunix.DefaultChecksum
+			}
+			if _, err := coreunix.NewChecksum(checksumAlg); err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+		} else {
+			checksumAlg = ""
+		}
+
+		if check {
+			// nothing is stored, so there's no root to do anything with
+			for _, opt := range []string{onlyHashOptionName, manifestOptionName, toMFSOptionName, metadataOptionName, coverOptionName, offsetIdxOptionName, provideOptionName, journalOptionName, verifyOptionName, exportOptionName} {
+				if req.Option(opt).Found() {
+					res.SetError(fmt.Errorf("--%s cannot be used with --%s", checkOptionName, opt), cmds.ErrClient)
+					return
+				}
+			}
+		}
+
+		if pinRulesPath != "" {
+			// the rules replace the pin of the root
+			for _, opt := range []string{onlyHashOptionName, checkOptionName, pinOptionName, recursivePinOptName} {
+				if req.Option(opt).Found() {
+					res.SetError(fmt.Errorf("--%s cannot be used with --%s", pinRulesOptionName, opt), cmds.ErrClient)
+					return
+				}
+			}
+		}
+
+		if appendTo != "" {
+			// the existing file is read from the node, and only the leaves
+			// the trickle layout builds can be appended to
+			for _, opt := range []string{onlyHashOptionName, checkOptionName, unixfsTypeOptionName, manifestOptionName} {
+				if req.Option(opt).Found() {
+					res.SetError(fmt.Errorf("--%s cannot be used with --%s", appendToOptionName, opt), cmds.ErrClient)
+					return
+				}
+			}
+		}
+
+		if proofFound {
+			if proofOffset < 0 {
+				res.SetError(fmt.Errorf("--%s must not be negative", proofOptionName), cmds.ErrClient)
+				return
+			}
+			// the proof is read back from the stored DAG
+			for _, opt := range []string{onlyHashOptionName, checkOptionName} {
+				if req.Option(opt).Found() {
+					res.SetError(fmt.Errorf("--%s cannot be used with --%s", proofOptionName, opt), cmds.ErrClient)
+					return
+				}
+			}
+		}
+
+		if manifestOut != "" {
+			// the blocks are read back from the stored files, and a split
+			// file is a directory of parts
+			for _, opt := range []string{onlyHashOptionName, checkOptionName, splitOptionName, manifestOptionName} {
+				if req.Option(opt).Found() {
+					res.SetError(fmt.Errorf("--%s cannot be used with --%s", manifestOutOptName, opt), cmds.ErrClient)
+					return
+				}
+			}
+		}
+
+		if maxBlocksFound {
+			if !showBlocks {
+				res.SetError(fmt.Errorf("--%s requires --%s", maxBlocksOptName, showBlocksOptName), cmds.ErrClient)
+				return
+			}
+			if maxBlocks <= 0 {
+				res.SetError(fmt.Errorf("--%s must be positive", maxBlocksOptName), cmds.ErrClient)
+				return
+			}
+		}
+		if showBlocks {
+			// the blocks are read back from the stored DAG
+			for _, opt := range []string{onlyHashOptionName, checkOptionName} {
+				if req.Option(opt).Found() {
+					res.SetError(fmt.Errorf("--%s cannot be used with --%s", showBlocksOptName, opt), cmds.ErrClient)
+					return
+				}
+			}
+		}
+
+		if !outBufFound {
+			outBuf = 8
+		} else if outBuf < 1 {
+			res.SetError(fmt.Errorf("--%s must be positive", outBufOptionName), cmds.ErrClient)
+			return
+		}
+
+		var expected key.Key
+		if expectFound {
+			expected = key.B58KeyDecode(expect)
+			if expected == "" {
+				res.SetError(fmt.Errorf("--%s: invalid hash %q", expectOptionName, expect), cmds.ErrClient)
+				return
+			}
+			// the wrapping directory is only built when the add is stored
+			if hash || check {
+				mode := onlyHashOptionName
+				if check {
+					mode = checkOptionName
+				}
+				for _, opt := range []string{metadataOptionName, coverOptionName, offsetIdxOptionName} {
+					if req.Option(opt).Found() {
+						res.SetError(fmt.Errorf("--%s cannot be used with --%s and --%s", expectOptionName, opt, mode), cmds.ErrClient)
+						return
+					}
+				}
+			}
+		}
+
+		if offsetIndex {
+			// the index is of a file, and these add directories
+			for _, opt := range []string{wrapOptionName, splitOptionName, manifestOptionName} {
+				if req.Option(opt).Found() {
+					res.SetError(fmt.Errorf("--%s cannot be used with --%s", offsetIdxOptionName, opt), cmds.ErrClient)
+					return
+				}
+			}
+		}
+
+		if preChunked {
+			// the chunks are given, and the file is built from them alone
+			for _, opt := range []string{chunkerOptionName, manifestOptionName, concatOptionName, splitOptionName, journalOptionName} {
+				if req.Option(opt).Found() {
+					res.SetError(fmt.Errorf("--%s cannot be used with --%s", preChunkedOptName, opt), cmds.ErrClient)
+					return
+				}
+			}
+		}
+
+		if concat {
+			for _, opt := range []string{manifestOptionName, journalOptionName} {
+				if req.Option(opt).Found() {
+					res.SetError(fmt.Errorf("--%s cannot be used with --%s", concatOptionName, opt), cmds.ErrClient)
+					return
+				}
+			}
+		}
+
+		if toMFS != "" {
+			if hash {
+				res.SetError(fmt.Errorf("--%s cannot be used with --%s", toMFSOptionName, onlyHashOptionName), cmds.ErrClient)
+				return
+			}
+			if !strings.HasPrefix(toMFS, "/") || gopath.Clean(toMFS) == "/" {
+				res.SetError(fmt.Errorf("--%s must be an absolute path below /", toMFSOptionName), cmds.ErrClient)
+				return
+			}
+			toMFS = gopath.Clean(toMFS)
+		} else if force {
+			res.SetError(fmt.Errorf("--%s requires --%s", forceOptionName, toMFSOptionName), cmds.ErrClient)
+			return
+		}
+
+		if metadataFound {
+			if hash {
+				res.SetError(fmt.Errorf("--%s cannot be used with --%s", metadataOptionName, onlyHashOptionName), cmds.ErrClient)
+				return
+			}
+			var obj map[string]interface{}
+			if err := json.Unmarshal([]byte(metadata), &obj); err != nil {
+				res.SetError(fmt.Errorf("--%s must be a JSON object: %s", metadataOptionName, err), cmds.ErrClient)
+				return
+			}
+		}
+
+		var cover []byte
+		if coverFound {
+			if hash {
+				res.SetError(fmt.Errorf("--%s cannot be used with --%s", coverOptionName, onlyHashOptionName), cmds.ErrClient)
+				return
+			}
+			fi, err := os.Stat(coverPath)
+			if err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+			if !fi.Mode().IsRegular() {
+				res.SetError(fmt.Errorf("--%s %s is not a regular file", coverOptionName, coverPath), cmds.ErrClient)
+				return
+			}
+			cover, err = ioutil.ReadFile(coverPath)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+		}
+		if coverNameFound {
+			if !coverFound {
+				res.SetError(fmt.Errorf("--%s requires --%s", coverNameOptName, coverOptionName), cmds.ErrClient)
+				return
+			}
+			switch {
+			case coverName == "" || strings.Contains(coverName, "/"):
+				res.SetError(fmt.Errorf("--%s must be a non-empty name without '/'", coverNameOptName), cmds.ErrClient)
+				return
+			case coverName == coreunix.MetadataDataLink || coverName == coreunix.MetadataMetaLink:
+				res.SetError(fmt.Errorf("--%s cannot be %q, it is used by the wrapping directory", coverNameOptName, coverName), cmds.ErrClient)
+				return
+			}
+		}
+
+		switch provide {
+		case "":
+		case "root", "all":
+			if !n.OnlineMode() {
+				res.SetError(errNotOnline, cmds.ErrClient)
+				return
+			}
+			if hash || local {
+				res.SetError(fmt.Errorf("--%s cannot be used with --%s or --%s", provideOptionName, onlyHashOptionName, localOptionName), cmds.ErrClient)
+				return
+			}
+		default:
+			res.SetError(fmt.Errorf("--%s must be 'root' or 'all'", provideOptionName), cmds.ErrClient)
+			return
+		}
+
+		if transform != "" {
+			if _, err := coreunix.NewTransform(transform); err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+		}
+
+		if writeRetries < 0 {
+			res.SetError(fmt.Errorf("--%s must not be negative", writeRetriesOptName), cmds.ErrClient)
+			return
+		}
+
+		if readerBufFound && readerBuf <= 0 {
+			res.SetError(fmt.Errorf("--%s must be positive", readerBufOptionName), cmds.ErrClient)
+			return
+		}
+
+		if workersFound && workers <= 0 {
+			res.SetError(fmt.Errorf("--%s must be positive", recWorkersOptName), cmds.ErrClient)
+			return
+		}
+
+		if maxTotalFound && maxTotal <= 0 {
+			res.SetError(fmt.Errorf("--%s must be positive", maxTotalOptionName), cmds.ErrClient)
+			return
+		}
+
+		if splitFound {
+			if split <= 0 {
+				res.SetError(fmt.Errorf("--%s must be positive", splitOptionName), cmds.ErrClient)
+				return
+			}
+			if appendTo != "" {
+				res.SetError(fmt.Errorf("--%s cannot be used with --%s", splitOptionName, appendToOptionName), cmds.ErrClient)
+				return
+			}
+		}
+
+		if maxDepthFound && maxDepth <= 0 {
+			res.SetError(fmt.Errorf("--%s must be positive", maxDepthOptionName), cmds.ErrClient)
+			return
+		}
+
+		if maxEntriesFound && maxEntries <= 0 {
+			res.SetError(fmt.Errorf("--%s must be positive", maxEntriesOptName), cmds.ErrClient)
+			return
+		}
+
+		if maxLinksFound && maxLinks < 2 {
+			res.SetError(fmt.Errorf("--%s must be at least 2", maxLinksOptionName), cmds.ErrClient)
+			return
+		}
+
+		if unixfsType != "" {
+			if rawLeaves {
+				res.SetError(fmt.Errorf("--%s cannot be used with --%s", unixfsTypeOptionName, rawLeavesOptionName), cmds.ErrClient)
+				return
+			}
+			if err := coreunix.ValidateUnixfsType(unixfsType); err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+		}
+
+		if onTruncateFound {
+			if err := coreunix.ValidateOnTruncate(onTruncate); err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+		}
+
+		if rawLeafMaxFound {
+			if !rawLeaves {
+				res.SetError(fmt.Errorf("--%s requires --%s", rawLeafMaxOptionName, rawLeavesOptionName), cmds.ErrClient)
+				return
+			}
+			if rawLeafMax <= 0 {
+				res.SetError(fmt.Errorf("--%s must be positive", rawLeafMaxOptionName), cmds.ErrClient)
+				return
+			}
+		}
+
+		includes := splitPatterns(include)
+		excludes := splitPatterns(exclude)
+		for _, p := range append(includes, excludes...) {
+			if err := coreunix.ValidateGlob(p); err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+		}
+		rejectTypes := splitPatterns(rejectType)
+		for _, p := range rejectTypes {
+			if err := coreunix.ValidateTypePattern(p); err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+		}
+		if len(rejectTypes) > 0 && manifest {
+			// the objects a manifest links to are not read
+			res.SetError(fmt.Errorf("--%s cannot be used with --%s", rejectTypeOptName, manifestOptionName), cmds.ErrClient)
+			return
+		}
 
 		if hash {
 			nilnode, err := core.NewNode(n.Context(), &core.BuildCfg{
@@ -130,10 +803,18 @@ MerkleDAG.
 			n = nilnode
 		}
 
-		outChan := make(chan interface{}, 8)
-		res.SetOutput((<-chan interface{})(outChan))
+		output := make(chan interface{}, outBuf)
+		res.SetOutput((<-chan interface{})(output))
+		// the adder writes here, and never waits long on the client
+		outChan := coreunix.CoalesceProgress(req.Context(), output)
 
-		fileAdder, err := coreunix.NewAdder(req.Context(), n, outChan)
+		newAdder := coreunix.NewAdder
+		if check {
+			newAdder = coreunix.NewCheckAdder
+		} else if local {
+			newAdder = coreunix.NewLocalAdder
+		}
+		fileAdder, err := newAdder(req.Context(), n, outChan)
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
@@ -141,16 +822,92 @@ MerkleDAG.
 		fileAdder.Chunker = chunker
 		fileAdder.Progress = progress
 		fileAdder.Hidden = hidden
+		fileAdder.HiddenFilesOnly = hiddenFiles
 		fileAdder.Trickle = trickle
 		fileAdder.Wrap = wrap
 		fileAdder.Pin = dopin
+		fileAdder.PinRootOnly = !recursive
+		fileAdder.Flush = flush
 		fileAdder.Silent = silent
+		fileAdder.RawLeaves = rawLeaves
+		fileAdder.RawLeafMax = rawLeafMax
+		fileAdder.UnixfsType = unixfsType
+		fileAdder.Include = includes
+		fileAdder.Exclude = excludes
+		fileAdder.RejectTypes = rejectTypes
+		fileAdder.RenameDuplicates = renameDups
+		fileAdder.NormalizeNames = normalize
+		fileAdder.Checksum = checksumAlg
+		fileAdder.MaxLinks = maxLinks
+		fileAdder.MaxDirEntries = maxEntries
+		fileAdder.WriteRetries = writeRetries
+		fileAdder.MaxDepth = maxDepth
+		fileAdder.MaxTotal = int64(maxTotal)
+		fileAdder.Split = int64(split)
+		fileAdder.ReaderBuffer = readerBuf
+		fileAdder.RecursiveWorkers = workers
+		fileAdder.OnTruncate = onTruncate
+		fileAdder.Transform = transform
+		fileAdder.Stats = stats
+		if metadataFound {
+			fileAdder.Metadata = []byte(metadata)
+		}
+		fileAdder.Cover = cover
+		fileAdder.OffsetIndex = offsetIndex
+		fileAdder.CoverName = coverName
+		if journalPath != "" {
+			journal, err := coreunix.OpenJournal(journalPath)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			fileAdder.Journal = journal
+		}
+		if nameIndexPath != "" {
+			fileAdder.NameIndex = coreunix.NewNameIndex(nameIndexPath)
+		}
+		if manifestOut != "" {
+			fileAdder.StreamManifest = coreunix.NewStreamManifest(manifestOut)
+		}
+		if pinRulesPath != "" {
+			rules, err := readPinRules(pinRulesPath)
+			if err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+			fileAdder.PinRules = rules
+			fileAdder.Pin = false
+		}
+		if appendTo != "" {
+			base, err := core.Resolve(req.Context(), n, path.Path(appendTo))
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			fileAdder.AppendTo = base
+			fileAdder.Trickle = true
+		}
+		if manifest {
+			// a manifest always describes a directory
+			fileAdder.Wrap = true
+		}
 
 		addAllAndPin := func(f files.File) error {
+			// release the pin lock if we return before pinning
+			defer fileAdder.Close()
+			if fileAdder.Journal != nil {
+				defer fileAdder.Journal.Close()
+			}
+
+			if concat {
+				// a single unnamed file, reported under its hash like stdin
+				f = files.NewSliceFile("", "", []files.File{coreunix.ConcatFile("", f)})
+			}
+
 			// Iterate over each top-level file and add individually. Otherwise the
 			// single files.File f is treated as a directory, affecting hidden file
 			// semantics.
-			for {
+			for added := 0; ; added++ {
 				file, err := f.NextFile()
 				if err == io.EOF {
 					// Finished the list of files.
@@ -158,12 +915,36 @@ MerkleDAG.
 				} else if err != nil {
 					return err
 				}
+				if appendTo != "" && (added > 0 || file.IsDirectory()) {
+					return fmt.Errorf("--%s takes a single file to append", appendToOptionName)
+				}
+				if preChunked {
+					if added > 0 || !file.IsDirectory() {
+						return fmt.Errorf("--%s takes a single directory of chunks", preChunkedOptName)
+					}
+					if err := fileAdder.AddPreChunked(file); err != nil {
+						return err
+					}
+					continue
+				}
+				if manifest {
+					if file.IsDirectory() {
+						return fmt.Errorf("manifest %s is a directory", file.FileName())
+					}
+					if err := fileAdder.AddManifest(file); err != nil {
+						return err
+					}
+					continue
+				}
 				if err := fileAdder.AddFile(file); err != nil {
 					return err
 				}
 			}
 
-			if hash {
+			if hash || check {
+				if expectFound {
+					return expectRoot(fileAdder, expected)
+				}
 				return nil
 			}
 
@@ -173,7 +954,74 @@ MerkleDAG.
 				return err
 			}
 
-			return fileAdder.PinRoot()
+			if expectFound {
+				if err := expectRoot(fileAdder, expected); err != nil {
+					return err
+				}
+			}
+
+			if err := fileAdder.PinRoot(); err != nil {
+				return err
+			}
+
+			if verify {
+				if err := fileAdder.Verify(); err != nil {
+					return err
+				}
+			}
+
+			// with --only-hash we returned above, and nothing is exported
+			if exportPath != "" {
+				if err := exportRoot(req.Context(), n, fileAdder, exportPath); err != nil {
+					return err
+				}
+			}
+
+			if provide != "" {
+				root, err := fileAdder.RootNode()
+				if err != nil {
+					return err
+				}
+				if err := provideAsync(n, root, provide == "all"); err != nil {
+					return err
+				}
+			}
+
+			if proofFound {
+				root, err := fileAdder.RootNode()
+				if err != nil {
+					return err
+				}
+				proof, err := coreunix.ProofForOffset(req.Context(), n.DAG, root, uint64(proofOffset))
+				if err != nil {
+					return err
+				}
+				outChan <- &coreunix.AddedObject{Proof: proof}
+			}
+
+			if showBlocks {
+				root, err := fileAdder.RootNode()
+				if err != nil {
+					return err
+				}
+				// streamed as they are found, the list of a large DAG is long
+				err = coreunix.ListBlocks(req.Context(), n.DAG, root, maxBlocks, func(k key.Key) error {
+					outChan <- &coreunix.AddedObject{Block: k.B58String()}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			if toMFS != "" {
+				root, err := fileAdder.RootNode()
+				if err != nil {
+					return err
+				}
+				return linkToMFS(n, toMFS, root, force)
+			}
+			return nil
 		}
 
 		go func() {
@@ -183,6 +1031,17 @@ MerkleDAG.
 				return
 			}
 
+			if fileAdder.NameIndex != nil {
+				if err := fileAdder.NameIndex.Commit(); err != nil {
+					res.SetError(err, cmds.ErrNormal)
+					return
+				}
+			}
+			if fileAdder.StreamManifest != nil {
+				if err := fileAdder.StreamManifest.Commit(); err != nil {
+					res.SetError(err, cmds.ErrNormal)
+				}
+			}
 		}()
 	},
 	PostRun: func(req cmds.Request, res cmds.Response) {
@@ -214,6 +1073,27 @@ MerkleDAG.
 			return
 		}
 
+		verbose, _, _ := req.Option(verboseOptionName).Bool()
+		check, _, _ := req.Option(checkOptionName).Bool()
+
+		// the session digest covers '<name><TAB><hash>' lines of every
+		// object reported, so that two adds can be compared at a glance
+		digestAlg, _, _ := req.Option(sessionDigestOptName).String()
+		var digest hash.Hash
+		if digestAlg != "" {
+			digest, err = coreunix.NewChecksum(digestAlg)
+			if err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+		}
+
+		flush, flushFound, _ := req.Option(flushOptionName).Bool()
+		dopin, pinFound, _ := req.Option(pinOptionName).Bool()
+		if flushFound && !flush && (dopin || !pinFound) {
+			fmt.Fprintln(res.Stderr(), "WARNING: pins were not flushed and are not durable until 'ipfs repo flush' is run or the daemon shuts down cleanly.")
+		}
+
 		var showProgressBar bool
 		if prgFound {
 			showProgressBar = progress
@@ -247,26 +1127,82 @@ MerkleDAG.
 		}
 
 		lastFile := ""
-		var totalProgress, prevFiles, lastBytes int64
+		var totalProgress, lastBytes int64
+		var entries int
+		var root *coreunix.AddedObject // the last object reported
 
 	LOOP:
 		for {
 			select {
 			case out, ok := <-outChan:
 				if !ok {
+					if showProgressBar && bar.Total > totalProgress {
+						// input ended before the expected size, finish at 100%
+						bar.Total = totalProgress
+						bar.Update()
+					}
 					break LOOP
 				}
 				output := out.(*coreunix.AddedObject)
-				if len(output.Hash) > 0 {
+				if output.Block != "" {
+					if showProgressBar {
+						fmt.Fprintf(res.Stderr(), "\033[2K\r")
+					}
+					if silent {
+						continue
+					}
+					if quiet {
+						fmt.Fprintln(res.Stdout(), output.Block)
+					} else {
+						fmt.Fprintf(res.Stdout(), "block %s\n", output.Block)
+					}
+				} else if output.Proof != nil {
+					if showProgressBar {
+						fmt.Fprintf(res.Stderr(), "\033[2K\r")
+					}
+					if !silent {
+						printProof(res.Stdout(), output.Proof, quiet)
+					}
+				} else if output.Summary != nil {
+					if showProgressBar {
+						fmt.Fprintf(res.Stderr(), "\033[2K\r")
+					}
+					if !silent {
+						printSummary(res.Stdout(), output.Summary)
+					}
+				} else if len(output.Hash) > 0 {
+					if digest != nil {
+						fmt.Fprintf(digest, "%s\t%s\n", output.Name, output.Hash)
+					}
+					root = output
 					if showProgressBar {
 						// clear progress bar line before we print "added x" output
 						fmt.Fprintf(res.Stderr(), "\033[2K\r")
 					}
 					if quiet {
 						fmt.Fprintf(res.Stdout(), "%s\n", output.Hash)
-					} else {
-						fmt.Fprintf(res.Stdout(), "added %s %s\n", output.Hash, output.Name)
+						continue
+					}
+
+					line := fmt.Sprintf("added %s %s", output.Hash, output.Name)
+					if check {
+						status := "missing"
+						if output.Present {
+							status = "present"
+						}
+						line = fmt.Sprintf("%s %s %s", status, output.Hash, output.Name)
+					}
+					if output.Checksum != "" {
+						line += " " + output.Checksum
+					}
+					if output.PinMode != "" {
+						line += " pin:" + output.PinMode
+					}
+					if verbose && output.Blocks > 0 {
+						// directories have no data blocks of their own
+						line += fmt.Sprintf(" (%d blocks, depth %d)", output.Blocks, output.Depth)
 					}
+					fmt.Fprintln(res.Stdout(), line)
 
 				} else {
 					log.Debugf("add progress: %v %v\n", output.Name, output.Bytes)
@@ -275,16 +1211,22 @@ MerkleDAG.
 						continue
 					}
 
-					if len(lastFile) == 0 {
-						lastFile = output.Name
-					}
 					if output.Name != lastFile || output.Bytes < lastBytes {
-						prevFiles += lastBytes
+						// moved on to the next file or directory
 						lastFile = output.Name
+						entries++
+						bar.Prefix(fmt.Sprintf("%d entries ", entries))
 					}
 					lastBytes = output.Bytes
-					delta := prevFiles + lastBytes - totalProgress
-					totalProgress = bar.Add64(delta)
+
+					// Total counts the bytes of all files read so far
+					if output.Total > totalProgress {
+						totalProgress = bar.Add64(output.Total - totalProgress)
+					}
+					if bar.Total > 0 && totalProgress > bar.Total {
+						// input is larger than expected, don't overshoot 100%
+						bar.Total = totalProgress
+					}
 				}
 
 				if showProgressBar {
@@ -299,6 +1241,253 @@ MerkleDAG.
 				}
 			}
 		}
+
+		if digest != nil && res.Error() == nil {
+			if showProgressBar {
+				fmt.Fprintf(res.Stderr(), "\033[2K\r")
+			}
+			fmt.Fprintf(res.Stdout(), "session digest %s:%x\n", digestAlg, digest.Sum(nil))
+		}
+
+		if hook, _, _ := req.Option(postAddExecOptName).String(); hook != "" && root != nil && res.Error() == nil {
+			if err := runPostAdd(hook, root, res.Stderr()); err != nil {
+				err = fmt.Errorf("--%s: %s", postAddExecOptName, err)
+				if fatal, _, _ := req.Option(postAddFatalOptName).Bool(); fatal {
+					res.SetError(err, cmds.ErrNormal)
+					return
+				}
+				fmt.Fprintf(res.Stderr(), "WARNING: %s\n", err)
+			}
+		}
 	},
 	Type: coreunix.AddedObject{},
 }
+
+// postAddTimeout is how long the --post-add-exec command may run.
+const postAddTimeout = time.Minute
+
+// runPostAdd runs the --post-add-exec command for the root of an add, with
+// its hash and name as arguments and in the environment, and its output going
+// to out. The command is split at whitespace and run as is, not by a shell,
+// so quotes are passed along rather than grouping arguments. It is killed if
+// it runs longer than postAddTimeout.
+func runPostAdd(command string, root *coreunix.AddedObject, out io.Writer) error {
+	args := strings.Fields(command)
+	cmd := exec.Command(args[0], append(args[1:], root.Hash, root.Name)...)
+	cmd.Env = append(os.Environ(), "IPFS_ADD_HASH="+root.Hash, "IPFS_ADD_NAME="+root.Name)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(postAddTimeout):
+		cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("%s did not finish within %s", args[0], postAddTimeout)
+	}
+}
+
+// printProof writes the hashes of a --proof-for-offset proof, one per line,
+// root first, after a line giving the bytes of the file the leaf holds.
+func printProof(w io.Writer, p *coreunix.Proof, quiet bool) {
+	if !quiet {
+		fmt.Fprintf(w, "proof for offset %d, in bytes %d-%d:\n", p.Offset, p.Start, p.Start+p.Size-1)
+	}
+	for _, h := range p.Hashes {
+		fmt.Fprintln(w, h)
+	}
+}
+
+// printSummary prints the totals and the file size histogram of an add.
+func printSummary(w io.Writer, s *coreunix.AddSummary) {
+	fmt.Fprintf(w, "%d files, %s\n", s.Files, humanize.IBytes(uint64(s.Bytes)))
+	for i, n := range s.Histogram {
+		switch {
+		case i == 0:
+			fmt.Fprintf(w, "  < %s: %d\n", humanize.IBytes(uint64(coreunix.SizeBuckets[i])), n)
+		case i == len(coreunix.SizeBuckets):
+			fmt.Fprintf(w, "  >= %s: %d\n", humanize.IBytes(uint64(coreunix.SizeBuckets[i-1])), n)
+		default:
+			fmt.Fprintf(w, "  %s - %s: %d\n", humanize.IBytes(uint64(coreunix.SizeBuckets[i-1])), humanize.IBytes(uint64(coreunix.SizeBuckets[i])), n)
+		}
+	}
+	fmt.Fprintf(w, "%d blocks, %d already stored, %s saved\n", s.Blocks, s.Existing, humanize.IBytes(uint64(s.BytesSaved)))
+}
+
+// linkToMFS links nd into the node's mfs at p, creating parent directories as
+// needed. An existing entry at p is only replaced if force is set.
+func linkToMFS(n *core.IpfsNode, p string, nd *dag.Node, force bool) error {
+	dir, name := gopath.Split(p)
+	if err := mfs.Mkdir(n.FilesRoot, dir, true, false); err != nil {
+		return err
+	}
+
+	fsn, err := mfs.Lookup(n.FilesRoot, dir)
+	if err != nil {
+		return err
+	}
+	pdir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	_, err = pdir.Child(name)
+	switch {
+	case err == nil && !force:
+		return fmt.Errorf("%s already exists in mfs, use --%s to replace it", p, forceOptionName)
+	case err == nil:
+		if err := pdir.Unlink(name); err != nil {
+			return err
+		}
+	case err != os.ErrNotExist:
+		return err
+	}
+
+	if err := pdir.AddChild(name, nd); err != nil {
+		return err
+	}
+	return mfs.FlushPath(n.FilesRoot, p)
+}
+
+// provideAsync announces the root of an add, or all of its blocks, to the
+// node's routing system in the background, as it can take long. Errors found
+// before the announcements start are returned; later ones are only logged, as
+// the add has completed by then.
+func provideAsync(n *core.IpfsNode, root *dag.Node, all bool) error {
+	k, err := root.Key()
+	if err != nil {
+		return err
+	}
+
+	keys := []key.Key{k}
+	if all {
+		set := key.NewKeySet()
+		if err := dag.EnumerateChildren(n.Context(), n.DAG, root, set); err != nil {
+			return err
+		}
+		keys = append(keys, set.Keys()...)
+	}
+
+	go func() {
+		for _, k := range keys {
+			ctx, cancel := context.WithTimeout(n.Context(), provideTimeout)
+			err := n.Routing.Provide(ctx, k)
+			cancel()
+			if err != nil {
+				log.Warningf("failed to provide %s: %s", k, err)
+			}
+		}
+		log.Infof("provided %d blocks of %s", len(keys), k)
+	}()
+	return nil
+}
+
+// preservePaths renames the top-level inputs in f after the paths they were
+// given as, so that the adder nests them in directories named like the path's
+// components. Absolute paths are nested from the filesystem root, while paths
+// leading out of the current directory can't be preserved.
+func preservePaths(f files.File, opts files.SerialOptions) (files.File, error) {
+	var out []files.File
+	for {
+		file, err := f.NextFile()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		fpath := file.FullPath()
+		if fpath == "" {
+			// stdin has no path to preserve
+			out = append(out, file)
+			continue
+		}
+
+		name := gopath.Clean(filepath.ToSlash(fpath))
+		name = strings.TrimPrefix(name, "/")
+		if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("cannot preserve path %s: it leads out of the current directory", fpath)
+		}
+
+		stat, err := os.Lstat(fpath)
+		if err != nil {
+			return nil, err
+		}
+		file.Close()
+
+		nf, err := files.NewSerialFileWithOptions(name, fpath, stat, opts)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, nf)
+	}
+	return files.NewSliceFile("", "", out), nil
+}
+
+// splitPatterns splits a comma-separated option value, dropping empty entries.
+func splitPatterns(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// exportRoot writes the DAG of the adder's root to the file at fpath, see
+// coreunix.ExportDAG.
+func exportRoot(ctx context.Context, n *core.IpfsNode, adder *coreunix.Adder, fpath string) error {
+	root, err := adder.RootNode()
+	if err != nil {
+		return err
+	}
+	k, err := root.Key()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+	if err := coreunix.ExportDAG(ctx, n.DAG, k, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// expectRoot returns an error naming both hashes if the adder's root is not
+// expected.
+func expectRoot(adder *coreunix.Adder, expected key.Key) error {
+	root, err := adder.RootNode()
+	if err != nil {
+		return err
+	}
+	k, err := root.Key()
+	if err != nil {
+		return err
+	}
+	if k != expected {
+		return fmt.Errorf("root %s does not match expected %s", k.B58String(), expected.B58String())
+	}
+	return nil
+}
+
+// readPinRules reads the --pin-rules file at path, see coreunix.ParsePinRules.
+func readPinRules(path string) ([]coreunix.PinRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return coreunix.ParsePinRules(f)
+}
@@ -4,12 +4,16 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
+	ipns "github.com/ipfs/go-ipfs/fuse/ipns"
 	nodeMount "github.com/ipfs/go-ipfs/fuse/node"
+	path "github.com/ipfs/go-ipfs/path"
 	config "github.com/ipfs/go-ipfs/repo/config"
 )
 
@@ -70,11 +74,38 @@ baz
 baz
 > cat /ipfs/QmWLdkp93sNxGRjnFHPaYg8tCQ35NBY3XPn6KiETd3Z4WR
 baz
+
+With --alias, the given names are added at the root of the IPFS mount,
+each leading to an immutable object, and the root lists them:
+
+> ipfs mount --alias foo=QmSh5e7S6fdcu75LAbXNZAFY2nGyZUJXyLCJDvn2zRkWyC
+> ls /ipfs
+foo
+> cat /ipfs/foo/bar
+baz
+
+With --case-insensitive, a name that matches no entry of a directory of
+the IPFS mount, or no alias, is looked up again ignoring case, for
+software that expects a case-insensitive filesystem, as is common on OS X:
+
+> cat /ipfs/QmSh5e7S6fdcu75LAbXNZAFY2nGyZUJXyLCJDvn2zRkWyC/BAR
+baz
 `,
 	},
+	Subcommands: map[string]*cmds.Command{
+		"refresh": mountRefreshCmd,
+	},
 	Options: []cmds.Option{
 		cmds.StringOption("ipfs-path", "f", "The path where IPFS should be mounted."),
 		cmds.StringOption("ipns-path", "n", "The path where IPNS should be mounted."),
+		cmds.StringOption("resolve-timeout", "Fail lookups of IPNS names that take longer than this to resolve, e.g. '30s'. Default: no timeout."),
+		cmds.IntOption("resolve-retries", "Retry lookups of IPNS names that failed to resolve this many times, waiting longer before each retry. The retries count against --resolve-timeout. Default: 0."),
+		cmds.StringOption("negative-cache-ttl", "Answer lookups of IPNS names that failed to resolve with 'not found' for this long before trying again, e.g. '5s', or '0' to always retry. Default: 5s."),
+		cmds.StringOption("volname", "Name the mounts <volname>-ipfs and <volname>-ipns, as shown by Finder on OS X or listed as their source on Linux."),
+		cmds.StringOption("idle-timeout", "Unmount each mount once nothing used it for this long, e.g. '1h'. Default: never."),
+		cmds.StringOption("alias", "Comma-separated '<name>=<hash or /ipfs/ path>' entries to list at the root of the IPFS mount, each leading to that object."),
+		cmds.BoolOption("case-insensitive", "In the IPFS mount, let a name that matches no entry of a directory match one that differs only in case. Of several such entries, the first in byte order is used."),
+		cmds.StringOption("pins-dir", "Also mount a read-only directory listing the recursively pinned objects by hash at this path."),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		cfg, err := req.InvocContext().GetConfig()
@@ -114,12 +145,106 @@ baz
 			nsdir = cfg.Mounts.IPNS // NB: be sure to not redeclare!
 		}
 
-		err = nodeMount.Mount(node, fsdir, nsdir)
+		var opts nodeMount.Options
+		timeout, found, err := req.Option("resolve-timeout").String()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		if found {
+			opts.ResolveTimeout, err = time.ParseDuration(timeout)
+			if err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+		}
+
+		opts.ResolveRetries, _, err = req.Option("resolve-retries").Int()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		if opts.ResolveRetries < 0 {
+			res.SetError(errors.New("--resolve-retries must not be negative"), cmds.ErrClient)
+			return
+		}
+
+		negTTL, found, err := req.Option("negative-cache-ttl").String()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		if found {
+			opts.NegativeCacheTTL, err = time.ParseDuration(negTTL)
+			if err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+			if opts.NegativeCacheTTL == 0 {
+				// zero means the default in the mount options
+				opts.NegativeCacheTTL = -1
+			}
+		}
+
+		opts.VolumeName, _, err = req.Option("volname").String()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		opts.PinsDir, _, err = req.Option("pins-dir").String()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		aliases, found, err := req.Option("alias").String()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		if found {
+			opts.Aliases, err = parseAliases(aliases)
+			if err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+		}
+
+		opts.CaseInsensitive, _, err = req.Option("case-insensitive").Bool()
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
 		}
 
+		idle, found, err := req.Option("idle-timeout").String()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		if found {
+			opts.IdleTimeout, err = time.ParseDuration(idle)
+			if err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+			if opts.IdleTimeout <= 0 {
+				res.SetError(errors.New("--idle-timeout must be positive"), cmds.ErrClient)
+				return
+			}
+		}
+
+		err = nodeMount.MountWithOptions(node, fsdir, nsdir, opts)
+		if err != nil {
+			code := cmds.ErrNormal
+			if cerr, ok := err.(*cmds.Error); ok {
+				// e.g. a missing or non-empty mountpoint
+				code = cerr.Code
+			}
+			res.SetError(err, code)
+			return
+		}
+
 		var output config.Mounts
 		output.IPFS = fsdir
 		output.IPNS = nsdir
@@ -131,7 +256,79 @@ baz
 			v := res.Output().(*config.Mounts)
 			s := fmt.Sprintf("IPFS mounted at: %s\n", v.IPFS)
 			s += fmt.Sprintf("IPNS mounted at: %s\n", v.IPNS)
+			if pins, _, _ := res.Request().Option("pins-dir").String(); pins != "" {
+				s += fmt.Sprintf("Pins mounted at: %s\n", pins)
+			}
 			return strings.NewReader(s), nil
 		},
 	},
 }
+
+// parseAliases parses the --alias list of '<name>=<path>' entries.
+func parseAliases(s string) (map[string]path.Path, error) {
+	aliases := make(map[string]path.Path)
+	for _, entry := range strings.Split(s, ",") {
+		i := strings.Index(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("--alias %q: expected '<name>=<path>'", entry)
+		}
+		name := entry[:i]
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("--alias %q: invalid name %q", entry, name)
+		}
+		if _, ok := aliases[name]; ok {
+			return nil, fmt.Errorf("--alias: %q given twice", name)
+		}
+
+		p, err := path.ParsePath(entry[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("--alias %q: %s", entry, err)
+		}
+		if p.Segments()[0] != "ipfs" {
+			// mutable names belong under the ipns mount
+			return nil, fmt.Errorf("--alias %q: only /ipfs/ paths can be aliased", entry)
+		}
+		aliases[name] = p
+	}
+	return aliases, nil
+}
+
+var mountRefreshCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Re-resolve an IPNS name under the mount.",
+		ShortDescription: `
+'ipfs mount refresh' drops the cached resolution of the given IPNS name,
+both the mount's and the node's name system's, so that the next access under
+the IPNS mountpoint resolves it again. Other cached names are not affected.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ipns-name", true, false, "The IPNS name to refresh."),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		node, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		node.Mounts.Lock()
+		m, ok := node.Mounts.Ipns.(*ipns.Mounted)
+		node.Mounts.Unlock()
+		if !ok || !m.IsActive() {
+			res.SetError(errors.New("ipns is not mounted"), cmds.ErrClient)
+			return
+		}
+
+		name := req.Arguments()[0]
+		if !m.Refresh(name) {
+			res.SetOutput(&MessageOutput{fmt.Sprintf("%s was not cached\n", name)})
+			return
+		}
+		res.SetOutput(&MessageOutput{fmt.Sprintf("refreshed %s\n", name)})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: MessageTextMarshaler,
+	},
+	Type: MessageOutput{},
+}
@@ -74,7 +74,10 @@ Publish an <ipfs-path> to another public key (not implemented):
 			}
 		}
 
-		if n.Mounts.Ipns != nil && n.Mounts.Ipns.IsActive() {
+		n.Mounts.Lock()
+		ipnsMount := n.Mounts.Ipns
+		n.Mounts.Unlock()
+		if ipnsMount != nil && ipnsMount.IsActive() {
 			res.SetError(errors.New("You cannot manually publish while IPNS is mounted."), cmds.ErrNormal)
 			return
 		}
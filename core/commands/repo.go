@@ -7,6 +7,7 @@ import (
 	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
 	u "gx/ipfs/QmZNVWh8LLjAavuQ2JXuFmuYH3C11xo988vSgp7UQrTRj1/go-ipfs-util"
 	"io"
+	"time"
 )
 
 var RepoCmd = &cmds.Command{
@@ -18,8 +19,9 @@ var RepoCmd = &cmds.Command{
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"gc":   repoGcCmd,
-		"stat": repoStatCmd,
+		"gc":    repoGcCmd,
+		"stat":  repoStatCmd,
+		"flush": repoFlushCmd,
 	},
 }
 
@@ -30,11 +32,16 @@ var repoGcCmd = &cmds.Command{
 'ipfs repo gc' is a plumbing command that will sweep the local
 set of stored objects and remove ones that are not pinned in
 order to reclaim hard disk space.
+
+A single GC of the repo runs at a time. If one is already running, as
+'ipfs repo stat' shows, this waits for it to finish before starting, or
+fails with --wait=false.
 `,
 	},
 
 	Options: []cmds.Option{
 		cmds.BoolOption("quiet", "q", "Write minimal output."),
+		cmds.BoolOption("wait", "Wait for a GC that is already running to finish, instead of failing. Default: true."),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		n, err := req.InvocContext().GetNode()
@@ -43,7 +50,12 @@ order to reclaim hard disk space.
 			return
 		}
 
-		gcOutChan, err := corerepo.GarbageCollectAsync(n, req.Context())
+		wait, found, _ := req.Option("wait").Bool()
+		if !found {
+			wait = true
+		}
+
+		gcOutChan, err := corerepo.GarbageCollectAsync(n, req.Context(), wait)
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
@@ -105,6 +117,7 @@ set of stored objects and print repo statistics. It outputs to stdout:
 NumObjects      int number of objects in the local repo
 RepoSize        int size in bytes that the repo is currently taking
 RepoPath        string the path to the repo being currently used	
+GC              whether a GC of the repo is running, and since when
 `,
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
@@ -147,8 +160,36 @@ RepoPath        string the path to the repo being currently used
 				fmt.Fprintf(buf, "RepoSize \t %d\n", stat.RepoSize)
 			}
 			fmt.Fprintf(buf, "RepoPath \t %s\n", stat.RepoPath)
+			if stat.GC.Running {
+				fmt.Fprintf(buf, "GC \t running since %s\n", stat.GC.Started.Format(time.RFC3339))
+			} else {
+				fmt.Fprintf(buf, "GC \t idle\n")
+			}
 
 			return buf, nil
 		},
 	},
 }
+
+var repoFlushCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Flush the pinset to disk.",
+		ShortDescription: `
+'ipfs repo flush' writes the in-memory pinset to the datastore, if any pins
+were left unflushed. Run it after 'ipfs add --flush=false' to make the pins
+of those adds durable.
+`,
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		if err := n.FlushPins(); err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+	},
+}
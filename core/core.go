@@ -14,6 +14,8 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/ipfs/go-datastore"
@@ -48,6 +50,7 @@ import (
 	bsnet "github.com/ipfs/go-ipfs/exchange/bitswap/network"
 	rp "github.com/ipfs/go-ipfs/exchange/reprovide"
 	mfs "github.com/ipfs/go-ipfs/mfs"
+	iolimit "github.com/ipfs/go-ipfs/thirdparty/iolimit"
 
 	mount "github.com/ipfs/go-ipfs/fuse/mount"
 	merkledag "github.com/ipfs/go-ipfs/merkledag"
@@ -55,6 +58,7 @@ import (
 	ipnsrp "github.com/ipfs/go-ipfs/namesys/republisher"
 	path "github.com/ipfs/go-ipfs/path"
 	pin "github.com/ipfs/go-ipfs/pin"
+	gc "github.com/ipfs/go-ipfs/pin/gc"
 	repo "github.com/ipfs/go-ipfs/repo"
 	config "github.com/ipfs/go-ipfs/repo/config"
 	uio "github.com/ipfs/go-ipfs/unixfs/io"
@@ -99,6 +103,8 @@ type IpfsNode struct {
 	Reporter   metrics.Reporter
 	Discovery  discovery.Service
 	FilesRoot  *mfs.Root
+	IOLimit    *iolimit.Limiter // shared by adds and GC, see Datastore.MaxIOConcurrency
+	GCLock     *gc.Lock         // lets a single GC of the node run at a time
 
 	// Online
 	PeerHost     p2phost.Host        // the network host (server+client)
@@ -115,14 +121,23 @@ type IpfsNode struct {
 	ctx  context.Context
 
 	mode mode
+
+	// set when the pinset was changed without being flushed to the
+	// datastore (see 'ipfs add --flush=false'), cleared by FlushPins
+	unflushedPins int32
 }
 
 // Mounts defines what the node's mount state is. This should
 // perhaps be moved to the daemon or mount. It's here because
 // it needs to be accessible across daemon requests.
 type Mounts struct {
+	// guards the mounts, which an idle mount clears on its own when
+	// unmounted (see fuse/node Options.IdleTimeout)
+	sync.Mutex
+
 	Ipfs mount.Mount
 	Ipns mount.Mount
+	Pins mount.Mount // listing of the recursive pins, if mounted
 }
 
 func (n *IpfsNode) startOnlineServices(ctx context.Context, routingOption RoutingOption, hostOption HostOption, do DiscoveryOption) error {
@@ -322,6 +337,13 @@ func (n *IpfsNode) teardown() error {
 	// owned objects are closed in this teardown to ensure that they're closed
 	// regardless of which constructor was used to add them to the node.
 	var closers []io.Closer
+	var errs []error
+
+	// write out any pins whose flush was deferred while the datastore is
+	// still open, so the window in which they can be lost ends here
+	if err := n.FlushPins(); err != nil {
+		errs = append(errs, err)
+	}
 
 	// NOTE: the order that objects are added(closed) matters, if an object
 	// needs to use another during its shutdown/cleanup process, it should be
@@ -335,12 +357,17 @@ func (n *IpfsNode) teardown() error {
 		closers = append(closers, n.Exchange)
 	}
 
+	n.Mounts.Lock()
 	if n.Mounts.Ipfs != nil && !n.Mounts.Ipfs.IsActive() {
 		closers = append(closers, mount.Closer(n.Mounts.Ipfs))
 	}
 	if n.Mounts.Ipns != nil && !n.Mounts.Ipns.IsActive() {
 		closers = append(closers, mount.Closer(n.Mounts.Ipns))
 	}
+	if n.Mounts.Pins != nil && !n.Mounts.Pins.IsActive() {
+		closers = append(closers, mount.Closer(n.Mounts.Pins))
+	}
+	n.Mounts.Unlock()
 
 	if dht, ok := n.Routing.(*dht.IpfsDHT); ok {
 		closers = append(closers, dht.Process())
@@ -361,7 +388,6 @@ func (n *IpfsNode) teardown() error {
 	// Repo closed last, most things need to preserve state here
 	closers = append(closers, n.Repo)
 
-	var errs []error
 	for _, closer := range closers {
 		if err := closer.Close(); err != nil {
 			errs = append(errs, err)
@@ -373,6 +399,26 @@ func (n *IpfsNode) teardown() error {
 	return nil
 }
 
+// DeferPinFlush records that the pinset was modified in memory without being
+// flushed. The pins are written out by FlushPins, at the latest when the node
+// shuts down.
+func (n *IpfsNode) DeferPinFlush() {
+	atomic.StoreInt32(&n.unflushedPins, 1)
+}
+
+// FlushPins writes pins whose flush was deferred to the datastore. It is a
+// no-op if there are none.
+func (n *IpfsNode) FlushPins() error {
+	if n.Pinning == nil || !atomic.CompareAndSwapInt32(&n.unflushedPins, 1, 0) {
+		return nil
+	}
+	if err := n.Pinning.Flush(); err != nil {
+		n.DeferPinFlush()
+		return err
+	}
+	return nil
+}
+
 func (n *IpfsNode) OnlineMode() bool {
 	switch n.mode {
 	case onlineMode:
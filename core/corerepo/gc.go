@@ -2,11 +2,14 @@ package corerepo
 
 import (
 	"errors"
+	"sync"
 	"time"
 
 	humanize "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/dustin/go-humanize"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
 	key "github.com/ipfs/go-ipfs/blocks/key"
 	"github.com/ipfs/go-ipfs/core"
+	mount "github.com/ipfs/go-ipfs/fuse/mount"
 	gc "github.com/ipfs/go-ipfs/pin/gc"
 	repo "github.com/ipfs/go-ipfs/repo"
 	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
@@ -71,10 +74,79 @@ func NewGC(n *core.IpfsNode) (*GC, error) {
 	}, nil
 }
 
-func GarbageCollect(n *core.IpfsNode, ctx context.Context) error {
+// inUse returns the objects being read through the node's mounts, which GC
+// keeps, as far as they are stored locally, so that a GC doesn't remove blocks
+// from under a process reading an unpinned file on a mount.
+func inUse(n *core.IpfsNode) []key.Key {
+	n.Mounts.Lock()
+	mounts := []mount.Mount{n.Mounts.Ipfs, n.Mounts.Ipns, n.Mounts.Pins}
+	n.Mounts.Unlock()
+
+	var keys []key.Key
+	for _, m := range mounts {
+		if m != nil {
+			keys = append(keys, mount.InUse(m)...)
+		}
+	}
+	return keys
+}
+
+// mark runs the first phase of a GC of n, and sets up the sweep as the node
+// is configured: at most Datastore.GCMaxDeleteRate blocks are removed per
+// second, each holding a slot of the node's IOLimit. With
+// Datastore.GCVerifyPins, the GC fails before marking anything if any pinned
+// block is missing, as the pinset can't be trusted then.
+func mark(n *core.IpfsNode, ctx context.Context) (*gc.Marked, error) {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Datastore.GCVerifyPins {
+		if err := gc.VerifyPins(ctx, n.Blockstore, n.Pinning); err != nil {
+			return nil, err
+		}
+	}
+
+	m, err := gc.Mark(ctx, n.Blockstore, n.Pinning, inUse(n))
+	if err != nil {
+		return nil, err
+	}
+	m.DeleteRate = cfg.Datastore.GCMaxDeleteRate
+	m.IOLimit = n.IOLimit
+	return m, nil
+}
+
+// GarbageCollect runs a GC, removing every block that isn't pinned or in use.
+// Blocks whose base58 key starts with one of sparePrefixes are kept as well,
+// for this run only. With olderThan non-zero, so are the blocks stored less
+// than olderThan ago; this fails with gc.ErrAgeUnsupported, removing nothing,
+// if the node's blockstore can't tell the age of its blocks. If another GC of
+// the node is running, it waits for it to finish first.
+func GarbageCollect(n *core.IpfsNode, ctx context.Context, sparePrefixes []string, olderThan time.Duration) error {
+	return garbageCollect(n, ctx, sparePrefixes, olderThan, true)
+}
+
+func garbageCollect(n *core.IpfsNode, ctx context.Context, sparePrefixes []string, olderThan time.Duration, wait bool) error {
+	if _, ok := n.Blockstore.(bstore.Ager); olderThan > 0 && !ok {
+		return gc.ErrAgeUnsupported
+	}
+
+	unlock, err := lockGC(n, ctx, wait)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel() // in case error occurs during operation
-	rmed, err := gc.GC(ctx, n.Blockstore, n.Pinning)
+	m, err := mark(n, ctx)
+	if err != nil {
+		return err
+	}
+	m.SparePrefixes = sparePrefixes
+	m.OlderThan = olderThan
+	rmed, err := gc.Sweep(ctx, n.Blockstore, m)
 	if err != nil {
 		return err
 	}
@@ -92,15 +164,150 @@ func GarbageCollect(n *core.IpfsNode, ctx context.Context) error {
 
 }
 
-func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) (<-chan *KeyRemoved, error) {
-	rmed, err := gc.GC(ctx, n.Blockstore, n.Pinning)
+// GCOptions holds optional hooks for GarbageCollectWithOptions.
+type GCOptions struct {
+	// OnScanned is called periodically with the number of blocks examined
+	// so far, and once more with the total when the sweep completes.
+	OnScanned func(scanned int64)
+
+	// OnRemoved is called for every block deleted, with its size in bytes.
+	OnRemoved func(k key.Key, size int64)
+
+	// ShouldRemove, if set, is asked about every block the GC would remove,
+	// and the block is kept for this run if it returns false. Pinned blocks
+	// and those in use are kept without asking, see gc.Marked.ShouldRemove.
+	ShouldRemove func(k key.Key) bool
+
+	// NoWait makes the GC fail with ErrGCInProgress if another GC of the
+	// node is running, rather than wait for it to finish.
+	NoWait bool
+}
+
+// GarbageCollectWithOptions runs a GC like GarbageCollect, calling the hooks
+// in opts as it progresses. The hooks run on the calling goroutine, decoupled
+// from the sweep: GC queues its events instead of waiting for them to be
+// handled, so a slow hook, or one that uses the blockstore, never stalls GC
+// while it holds the blockstore's GC lock.
+func GarbageCollectWithOptions(n *core.IpfsNode, ctx context.Context, opts GCOptions) error {
+	unlock, err := lockGC(n, ctx, !opts.NoWait)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // in case error occurs during operation
+	m, err := mark(n, ctx)
+	if err != nil {
+		return err
+	}
+	m.ShouldRemove = opts.ShouldRemove
+	results, err := gc.SweepWithProgress(ctx, n.Blockstore, m)
+	if err != nil {
+		return err
+	}
+
+	var (
+		mu      sync.Mutex
+		pending []gc.Result
+		done    bool
+	)
+	notify := make(chan struct{}, 1)
+	wake := func() {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+	go func() {
+		for r := range results {
+			mu.Lock()
+			pending = append(pending, r)
+			mu.Unlock()
+			wake()
+		}
+		mu.Lock()
+		done = true
+		mu.Unlock()
+		wake()
+	}()
+
+	for {
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		mu.Lock()
+		batch, finished := pending, done
+		pending = nil
+		mu.Unlock()
+
+		for _, r := range batch {
+			if r.Error != nil {
+				return r.Error
+			}
+			if r.Key != "" && opts.OnRemoved != nil {
+				opts.OnRemoved(r.Key, r.Size)
+			}
+			if opts.OnScanned != nil {
+				opts.OnScanned(r.Scanned)
+			}
+		}
+		if finished {
+			return nil
+		}
+	}
+}
+
+// GarbageCollectEstimate reports how many blocks, and how many bytes, a GC
+// would free right now, without removing anything. It is safe to run while the
+// node is in use.
+func GarbageCollectEstimate(n *core.IpfsNode, ctx context.Context) (blocks int64, bytes int64, err error) {
+	return gc.Estimate(ctx, n.Blockstore, n.Pinning, inUse(n))
+}
+
+// ExplainReachability reports whether a GC would keep the block k, running
+// the same marking as GC does, and if so, the path of keys that reaches it:
+// from a pin, or from an object in use on a mount, down to k. It is safe to
+// run while the node is in use.
+func ExplainReachability(n *core.IpfsNode, ctx context.Context, k key.Key) (reachable bool, path []key.Key, err error) {
+	return gc.Explain(ctx, n.Blockstore, n.Pinning, inUse(n), k)
+}
+
+// GarbageCollectAsync runs a GC in the background, sending the key of every
+// block it removes. If another GC of the node is running, it waits for
+// it to finish first, or fails with ErrGCInProgress if wait is unset.
+func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context, wait bool) (<-chan *KeyRemoved, error) {
+	unlock, err := lockGC(n, ctx, wait)
+	if err != nil {
+		return nil, err
+	}
+	m, err := mark(n, ctx)
 	if err != nil {
+		unlock()
 		return nil, err
 	}
+	rmed, err := gc.Sweep(ctx, n.Blockstore, m)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return keysRemoved(ctx, rmed, unlock), nil
+}
 
+// keysRemoved relays rmed, calling done once the sweep sending to it ended.
+func keysRemoved(ctx context.Context, rmed <-chan key.Key, done func()) <-chan *KeyRemoved {
 	out := make(chan *KeyRemoved)
 	go func() {
 		defer close(out)
+		defer func() {
+			// the sweep stops as well once ctx is done
+			for range rmed {
+			}
+			done()
+		}()
 		for k := range rmed {
 			select {
 			case out <- &KeyRemoved{k}:
@@ -109,7 +316,60 @@ func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) (<-chan *KeyRemo
 			}
 		}
 	}()
-	return out, nil
+	return out
+}
+
+// ReachableSet is the set of blocks a GC keeps, as computed by MarkReachable.
+// Until it is swept by SweepUnreachable or released, it holds the blockstore's
+// GC lock, which blocks adds and pins on the node; keep that window short. It
+// also holds the node's own GC lock, so that it counts as a running GC.
+type ReachableSet struct {
+	marked *gc.Marked
+	unlock func()
+}
+
+// Has returns whether the block k is reachable.
+func (s ReachableSet) Has(k key.Key) bool {
+	return s.marked.Keys.Has(k)
+}
+
+// Keys returns the keys of all reachable blocks.
+func (s ReachableSet) Keys() []key.Key {
+	return s.marked.Keys.Keys()
+}
+
+// Release gives up the set without sweeping, releasing the GC locks.
+func (s ReachableSet) Release() {
+	s.marked.Release()
+	s.unlock()
+}
+
+// MarkReachable computes the set of blocks reachable from the node's pins and
+// from the files open on its mounts, the first phase of a GC. If another GC
+// of the node is running, it waits for it to finish first. The set must be
+// passed to SweepUnreachable or released.
+func MarkReachable(n *core.IpfsNode, ctx context.Context) (ReachableSet, error) {
+	unlock, err := lockGC(n, ctx, true)
+	if err != nil {
+		return ReachableSet{}, err
+	}
+	m, err := mark(n, ctx)
+	if err != nil {
+		unlock()
+		return ReachableSet{}, err
+	}
+	return ReachableSet{marked: m, unlock: unlock}, nil
+}
+
+// SweepUnreachable removes every block not in set, the second phase of a GC,
+// and releases the set when done.
+func SweepUnreachable(n *core.IpfsNode, ctx context.Context, set ReachableSet) (<-chan *KeyRemoved, error) {
+	rmed, err := gc.Sweep(ctx, n.Blockstore, set.marked)
+	if err != nil {
+		set.unlock()
+		return nil, err
+	}
+	return keysRemoved(ctx, rmed, set.unlock), nil
 }
 
 func PeriodicGC(ctx context.Context, node *core.IpfsNode) error {
@@ -176,7 +436,12 @@ func (gc *GC) maybeGC(ctx context.Context, offset uint64) error {
 		_ctx, cancel := context.WithTimeout(ctx, time.Duration(gc.SlackGB)*time.Minute)
 		defer cancel()
 
-		if err := GarbageCollect(gc.Node, _ctx); err != nil {
+		// a GC that is already running frees the space as well
+		if err := garbageCollect(gc.Node, _ctx, nil, 0, false); err != nil {
+			if err == ErrGCInProgress {
+				log.Info("Repo GC already in progress, skipping")
+				return nil
+			}
 			return err
 		}
 		newStorage, err := gc.Repo.GetStorageUsage()
@@ -0,0 +1,598 @@
+package corerepo
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	"github.com/ipfs/go-ipfs/core"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/pin"
+	gc "github.com/ipfs/go-ipfs/pin/gc"
+	"github.com/ipfs/go-ipfs/repo"
+	"github.com/ipfs/go-ipfs/repo/config"
+	"github.com/ipfs/go-ipfs/thirdparty/testutil"
+	goprocess "gx/ipfs/QmQopLATEYMNg7dVqZRNDfeE2S1yKy8zrRh5xnYiuqeZBn/goprocess"
+	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+func newTestNode(t *testing.T) *core.IpfsNode {
+	r := &repo.Mock{
+		C: config.Config{
+			Identity: config.Identity{
+				PeerID: "Qmfoo", // required by offline node
+			},
+		},
+		D: testutil.ThreadSafeCloserMapDatastore(),
+	}
+	n, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// putBlocks stores count blocks and returns their sizes by key.
+func putBlocks(t *testing.T, n *core.IpfsNode, prefix string, count int) map[key.Key]int64 {
+	sizes := make(map[key.Key]int64)
+	for i := 0; i < count; i++ {
+		b := blocks.NewBlock([]byte(fmt.Sprintf("%s block %d", prefix, i)))
+		if err := n.Blockstore.Put(b); err != nil {
+			t.Fatal(err)
+		}
+		sizes[b.Key()] = int64(len(b.Data))
+	}
+	return sizes
+}
+
+func TestGarbageCollectWithOptions(t *testing.T) {
+	n := newTestNode(t)
+
+	garbage := putBlocks(t, n, "garbage", 10)
+	kept := putBlocks(t, n, "pinned", 3)
+	for k := range kept {
+		n.Pinning.PinWithMode(k, pin.Direct)
+	}
+
+	removed := make(map[key.Key]int64)
+	var scanned int64
+	opts := GCOptions{
+		OnRemoved: func(k key.Key, size int64) {
+			removed[k] = size
+			// hooks must be able to use the blockstore without deadlocking GC
+			n.Blockstore.PinLock().Unlock()
+		},
+		OnScanned: func(s int64) {
+			if s < scanned {
+				t.Errorf("scan count went backwards: %d after %d", s, scanned)
+			}
+			scanned = s
+		},
+	}
+	if err := GarbageCollectWithOptions(n, context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(removed) != len(garbage) {
+		t.Fatalf("expected %d removed blocks, got %d", len(garbage), len(removed))
+	}
+	for k, size := range garbage {
+		if removed[k] != size {
+			t.Errorf("block %s: reported size %d, expected %d", k, removed[k], size)
+		}
+	}
+	if scanned < int64(len(garbage)+len(kept)) {
+		t.Errorf("expected at least %d blocks scanned, got %d", len(garbage)+len(kept), scanned)
+	}
+	for k := range kept {
+		has, err := n.Blockstore.Has(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Errorf("pinned block %s was removed", k)
+		}
+	}
+}
+
+func TestGarbageCollectEstimate(t *testing.T) {
+	n := newTestNode(t)
+
+	garbage := putBlocks(t, n, "garbage", 5)
+	for k := range putBlocks(t, n, "pinned", 2) {
+		n.Pinning.PinWithMode(k, pin.Direct)
+	}
+
+	var expected int64
+	for _, size := range garbage {
+		expected += size
+	}
+
+	count, size, err := GarbageCollectEstimate(n, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != int64(len(garbage)) || size != expected {
+		t.Fatalf("expected %d blocks of %d bytes, got %d blocks of %d bytes", len(garbage), expected, count, size)
+	}
+
+	// nothing may have been removed
+	for k := range garbage {
+		has, err := n.Blockstore.Has(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Fatalf("estimate removed block %s", k)
+		}
+	}
+}
+
+func TestMarkAndSweep(t *testing.T) {
+	n := newTestNode(t)
+
+	garbage := putBlocks(t, n, "garbage", 4)
+	kept := putBlocks(t, n, "pinned", 2)
+	for k := range kept {
+		n.Pinning.PinWithMode(k, pin.Direct)
+	}
+
+	set, err := MarkReachable(n, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range kept {
+		if !set.Has(k) {
+			t.Fatalf("pinned block %s not marked", k)
+		}
+	}
+	for k := range garbage {
+		if set.Has(k) {
+			t.Fatalf("unpinned block %s marked", k)
+		}
+	}
+
+	rmed, err := SweepUnreachable(n, context.Background(), set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for r := range rmed {
+		if _, ok := garbage[r.Key]; !ok {
+			t.Fatalf("unexpected block %s removed", r.Key)
+		}
+		count++
+	}
+	if count != len(garbage) {
+		t.Fatalf("expected %d removed blocks, got %d", len(garbage), count)
+	}
+
+	// the set was consumed, and the GC lock released
+	if _, err := SweepUnreachable(n, context.Background(), set); err == nil {
+		t.Fatal("expected sweeping a set twice to fail")
+	}
+	n.Blockstore.PinLock().Unlock()
+}
+
+// fakeMount is a mount with files open.
+type fakeMount struct {
+	open []key.Key
+}
+
+func (m *fakeMount) MountPoint() string         { return "/fake" }
+func (m *fakeMount) Unmount() error             { return nil }
+func (m *fakeMount) IsActive() bool             { return true }
+func (m *fakeMount) Process() goprocess.Process { return nil }
+func (m *fakeMount) InUse() []key.Key           { return m.open }
+
+func TestGarbageCollectKeepsMountedFiles(t *testing.T) {
+	n := newTestNode(t)
+
+	child := &dag.Node{Data: []byte("child")}
+	if _, err := n.DAG.Add(child); err != nil {
+		t.Fatal(err)
+	}
+	// not fetched yet, as happens for a file being streamed
+	missing := &dag.Node{Data: []byte("missing")}
+
+	root := &dag.Node{Data: []byte("root")}
+	if err := root.AddNodeLink("child", child); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLink("missing", missing); err != nil {
+		t.Fatal(err)
+	}
+	rk, err := n.DAG.Add(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ck, err := child.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	garbage := putBlocks(t, n, "garbage", 3)
+	n.Mounts.Ipfs = &fakeMount{open: []key.Key{rk}}
+
+	if err := GarbageCollect(n, context.Background(), nil, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []key.Key{rk, ck} {
+		if has, err := n.Blockstore.Has(k); err != nil || !has {
+			t.Fatalf("block %s of an open file was removed", k)
+		}
+	}
+	for k := range garbage {
+		if has, _ := n.Blockstore.Has(k); has {
+			t.Fatalf("unreferenced block %s was kept", k)
+		}
+	}
+}
+
+func TestGarbageCollectSparePrefixes(t *testing.T) {
+	n := newTestNode(t)
+
+	garbage := putBlocks(t, n, "garbage", 10)
+	var spare key.Key
+	for k := range garbage {
+		spare = k
+		break
+	}
+	prefix := spare.B58String()[:8]
+
+	if err := GarbageCollect(n, context.Background(), []string{prefix}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for k := range garbage {
+		has, err := n.Blockstore.Has(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := strings.HasPrefix(k.B58String(), prefix); has != want {
+			t.Fatalf("block %s: expected kept %t, got %t", k, want, has)
+		}
+	}
+}
+
+func TestGarbageCollectDeleteRate(t *testing.T) {
+	n := newTestNode(t)
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Datastore.GCMaxDeleteRate = 50
+
+	garbage := putBlocks(t, n, "garbage", 10)
+
+	start := time.Now()
+	if err := GarbageCollect(n, context.Background(), nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	// 10 deletions at 50 per second take at least 200ms
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected GC to be slowed down by the delete rate, took %s", elapsed)
+	}
+
+	for k := range garbage {
+		if has, _ := n.Blockstore.Has(k); has {
+			t.Fatalf("unreferenced block %s was kept", k)
+		}
+	}
+}
+
+func TestGarbageCollectHugeDeleteRate(t *testing.T) {
+	n := newTestNode(t)
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// more than a block per nanosecond, as good as unlimited
+	cfg.Datastore.GCMaxDeleteRate = int(^uint(0) >> 1)
+
+	garbage := putBlocks(t, n, "garbage", 3)
+	if err := GarbageCollect(n, context.Background(), nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	for k := range garbage {
+		if has, _ := n.Blockstore.Has(k); has {
+			t.Fatalf("unreferenced block %s was kept", k)
+		}
+	}
+}
+
+func TestGarbageCollectVerifyPins(t *testing.T) {
+	n := newTestNode(t)
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Datastore.GCVerifyPins = true
+
+	child := &dag.Node{Data: []byte("child")}
+	if _, err := n.DAG.Add(child); err != nil {
+		t.Fatal(err)
+	}
+	missing := &dag.Node{Data: []byte("missing")}
+	root := &dag.Node{Data: []byte("root")}
+	if err := root.AddNodeLink("child", child); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLink("missing", missing); err != nil {
+		t.Fatal(err)
+	}
+	rk, err := n.DAG.Add(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Pinning.PinWithMode(rk, pin.Recursive)
+
+	mk, err := missing.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	direct := blocks.NewBlock([]byte("direct, never stored")).Key()
+	n.Pinning.PinWithMode(direct, pin.Direct)
+
+	garbage := putBlocks(t, n, "garbage", 3)
+
+	err = GarbageCollect(n, context.Background(), nil, 0)
+	merr, ok := err.(*gc.MissingPinsError)
+	if !ok {
+		t.Fatalf("expected a MissingPinsError, got %v", err)
+	}
+	if len(merr.Keys) != 2 || merr.Keys[0] != mk || merr.Keys[1] != direct {
+		t.Fatalf("expected %s and %s to be reported missing, got %v", mk, direct, merr.Keys)
+	}
+
+	for k := range garbage {
+		if has, _ := n.Blockstore.Has(k); !has {
+			t.Fatalf("block %s was removed by a GC that failed", k)
+		}
+	}
+}
+
+func TestExplainReachability(t *testing.T) {
+	n := newTestNode(t)
+
+	var keys []key.Key
+	var below *dag.Node
+	for _, data := range []string{"grandchild", "child", "root"} {
+		nd := &dag.Node{Data: []byte(data)}
+		if below != nil {
+			if err := nd.AddNodeLink(string(below.Data), below); err != nil {
+				t.Fatal(err)
+			}
+		}
+		k, err := n.DAG.Add(nd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append([]key.Key{k}, keys...)
+		below = nd
+	}
+	n.Pinning.PinWithMode(keys[0], pin.Recursive)
+
+	var direct, garbage key.Key
+	for k := range putBlocks(t, n, "direct", 1) {
+		direct = k
+	}
+	for k := range putBlocks(t, n, "garbage", 1) {
+		garbage = k
+	}
+	n.Pinning.PinWithMode(direct, pin.Direct)
+
+	for _, c := range []struct {
+		target key.Key
+		path   []key.Key
+	}{
+		{keys[2], keys},
+		{direct, []key.Key{direct}},
+		{garbage, nil},
+	} {
+		reachable, path, err := ExplainReachability(n, context.Background(), c.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reachable != (c.path != nil) {
+			t.Fatalf("%s: expected reachable to be %t", c.target, c.path != nil)
+		}
+		if fmt.Sprint(path) != fmt.Sprint(c.path) {
+			t.Fatalf("%s: expected path %v, got %v", c.target, c.path, path)
+		}
+	}
+}
+
+// agedBlockstore reports the times of stored as when its blocks were stored,
+// and now for the others.
+type agedBlockstore struct {
+	bstore.GCBlockstore
+	stored map[key.Key]time.Time
+}
+
+func (bs *agedBlockstore) StoredAt(k key.Key) (time.Time, error) {
+	if t, ok := bs.stored[k]; ok {
+		return t, nil
+	}
+	return time.Now(), nil
+}
+
+func TestGarbageCollectOlderThan(t *testing.T) {
+	n := newTestNode(t)
+	old := putBlocks(t, n, "old", 3)
+	recent := putBlocks(t, n, "recent", 3)
+
+	if err := GarbageCollect(n, context.Background(), nil, time.Hour); err != gc.ErrAgeUnsupported {
+		t.Fatalf("expected ErrAgeUnsupported, got %v", err)
+	}
+	for _, set := range []map[key.Key]int64{old, recent} {
+		for k := range set {
+			if has, _ := n.Blockstore.Has(k); !has {
+				t.Fatalf("block %s was removed by a GC that failed", k)
+			}
+		}
+	}
+
+	bs := &agedBlockstore{GCBlockstore: n.Blockstore, stored: make(map[key.Key]time.Time)}
+	for k := range old {
+		bs.stored[k] = time.Now().Add(-2 * time.Hour)
+	}
+	for k := range recent {
+		bs.stored[k] = time.Now()
+	}
+
+	m, err := gc.Mark(context.Background(), bs, n.Pinning, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.OlderThan = time.Hour
+	rmed, err := gc.Sweep(context.Background(), bs, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := 0
+	for k := range rmed {
+		if _, ok := old[k]; !ok {
+			t.Fatalf("removed %s, which is not old", k)
+		}
+		removed++
+	}
+	if removed != len(old) {
+		t.Fatalf("expected %d old blocks to be removed, got %d", len(old), removed)
+	}
+}
+
+func TestGarbageCollectInProgress(t *testing.T) {
+	n := newTestNode(t)
+	garbage := putBlocks(t, n, "garbage", 3)
+
+	// stands in for a GC that is running
+	unlock, err := lockGC(n, context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !GCStatus(n).Running {
+		t.Fatal("expected the GC to be reported as running")
+	}
+
+	err = GarbageCollectWithOptions(n, context.Background(), GCOptions{NoWait: true})
+	if err != ErrGCInProgress {
+		t.Fatalf("expected ErrGCInProgress, got %v", err)
+	}
+	if _, err := GarbageCollectAsync(n, context.Background(), false); err != ErrGCInProgress {
+		t.Fatalf("expected ErrGCInProgress, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- GarbageCollect(n, context.Background(), nil, 0)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("GC ran while another was in progress: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	for k := range garbage {
+		if has, _ := n.Blockstore.Has(k); !has {
+			t.Fatalf("block %s was removed while another GC was in progress", k)
+		}
+	}
+
+	unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GC still waiting after the running one finished")
+	}
+	for k := range garbage {
+		if has, _ := n.Blockstore.Has(k); has {
+			t.Fatalf("block %s was not removed", k)
+		}
+	}
+	if GCStatus(n).Running {
+		t.Fatal("expected no GC to be reported as running")
+	}
+}
+
+func TestMarkReachableInProgress(t *testing.T) {
+	n := newTestNode(t)
+	putBlocks(t, n, "garbage", 3)
+
+	for _, release := range []bool{false, true} {
+		set, err := MarkReachable(n, context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !GCStatus(n).Running {
+			t.Fatal("expected a marked set to be reported as a running GC")
+		}
+		err = GarbageCollectWithOptions(n, context.Background(), GCOptions{NoWait: true})
+		if err != ErrGCInProgress {
+			t.Fatalf("expected ErrGCInProgress, got %v", err)
+		}
+
+		if release {
+			set.Release()
+		} else {
+			rmed, err := SweepUnreachable(n, context.Background(), set)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range rmed {
+			}
+		}
+		if GCStatus(n).Running {
+			t.Fatal("expected no GC to be reported as running")
+		}
+	}
+}
+
+func TestGarbageCollectShouldRemove(t *testing.T) {
+	n := newTestNode(t)
+	vetoed := putBlocks(t, n, "vetoed", 3)
+	garbage := putBlocks(t, n, "garbage", 3)
+	kept := putBlocks(t, n, "pinned", 2)
+	for k := range kept {
+		n.Pinning.PinWithMode(k, pin.Direct)
+	}
+
+	asked := make(map[key.Key]bool)
+	opts := GCOptions{
+		ShouldRemove: func(k key.Key) bool {
+			asked[k] = true
+			_, veto := vetoed[k]
+			return !veto
+		},
+	}
+	if err := GarbageCollectWithOptions(n, context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	for k := range kept {
+		if asked[k] {
+			t.Errorf("asked about pinned block %s", k)
+		}
+	}
+	for _, c := range []struct {
+		blocks map[key.Key]int64
+		stored bool
+	}{{vetoed, true}, {garbage, false}, {kept, true}} {
+		for k := range c.blocks {
+			has, err := n.Blockstore.Has(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if has != c.stored {
+				t.Errorf("block %s: expected stored to be %t", k, c.stored)
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package corerepo
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core"
+	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+// ErrGCInProgress is returned by a GC asked not to wait for another GC of the
+// node that is already running.
+var ErrGCInProgress = errors.New("GC already in progress")
+
+// GCState describes the GC of a node, as reported by GCStatus.
+type GCState struct {
+	Running bool
+	Started time.Time // of the running GC, zero if none is
+}
+
+// lockGC takes n's GC lock, waiting for a running GC to finish unless wait is
+// unset, in which case it fails with ErrGCInProgress. The returned func
+// releases the lock, and may be called more than once.
+func lockGC(n *core.IpfsNode, ctx context.Context, wait bool) (func(), error) {
+	if wait {
+		if err := n.GCLock.Acquire(ctx); err != nil {
+			return nil, err
+		}
+	} else if !n.GCLock.TryAcquire() {
+		return nil, ErrGCInProgress
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(n.GCLock.Release)
+	}, nil
+}
+
+// GCStatus reports whether a GC of n is running, and since when.
+func GCStatus(n *core.IpfsNode) GCState {
+	started := n.GCLock.Started()
+	return GCState{Running: !started.IsZero(), Started: started}
+}
@@ -1,21 +1,31 @@
 package coreunix
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"os"
 	gopath "path"
+	"strings"
+	"sync/atomic"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/ipfs/go-datastore"
 	syncds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/ipfs/go-datastore/sync"
+	blocks "github.com/ipfs/go-ipfs/blocks"
 	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
 	key "github.com/ipfs/go-ipfs/blocks/key"
 	bserv "github.com/ipfs/go-ipfs/blockservice"
+	exchange "github.com/ipfs/go-ipfs/exchange"
 	"github.com/ipfs/go-ipfs/exchange/offline"
-	importer "github.com/ipfs/go-ipfs/importer"
+	balanced "github.com/ipfs/go-ipfs/importer/balanced"
 	"github.com/ipfs/go-ipfs/importer/chunk"
+	h "github.com/ipfs/go-ipfs/importer/helpers"
+	trickle "github.com/ipfs/go-ipfs/importer/trickle"
 	mfs "github.com/ipfs/go-ipfs/mfs"
 	"github.com/ipfs/go-ipfs/pin"
 	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
@@ -35,6 +45,25 @@ var folderData = unixfs.FolderPBData()
 // how many bytes of progress to wait before sending a progress update message
 const progressReaderIncrement = 1024 * 256
 
+// DefaultMaxDirEntries is the number of entries a directory may have when the
+// adder's MaxDirEntries is not set. Each directory is a single node, which
+// gets impractical to fetch well before this many links; no common tree has
+// directories this large.
+const DefaultMaxDirEntries = 1 << 18
+
+// ErrDepthLimitExceeded is the cause of a DepthLimitError.
+var ErrDepthLimitExceeded = errors.New("depth limit exceeded")
+
+// DepthLimitError is returned when a directory being added is nested deeper
+// than the adder's MaxDepth.
+type DepthLimitError struct {
+	Path string
+}
+
+func (e *DepthLimitError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, ErrDepthLimitExceeded)
+}
+
 type Link struct {
 	Name, Hash string
 	Size       uint64
@@ -62,66 +91,236 @@ func (e *ignoreFileError) Error() string {
 }
 
 type AddedObject struct {
-	Name  string
-	Hash  string `json:",omitempty"`
-	Bytes int64  `json:",omitempty"`
+	Name     string
+	Hash     string      `json:",omitempty"`
+	Bytes    int64       `json:",omitempty"`
+	Checksum string      `json:",omitempty"`
+	Total    int64       `json:",omitempty"` // bytes of all files so far, in progress updates
+	Summary  *AddSummary `json:",omitempty"` // set on the last object, with Stats
+	Blocks   int64       `json:",omitempty"` // data blocks of an added file
+	Depth    int         `json:",omitempty"` // levels of links above the file's data blocks
+	Present  bool        `json:",omitempty"` // all blocks were already stored, see NewCheckAdder
+	PinMode  string      `json:",omitempty"` // how the file was pinned, see Adder.PinRules
+	Proof    *Proof      `json:",omitempty"` // sent on its own, see ProofForOffset
+	Block    string      `json:",omitempty"` // sent on its own, see ListBlocks
 }
 
 func NewAdder(ctx context.Context, n *core.IpfsNode, out chan interface{}) (*Adder, error) {
-	mr, err := mfs.NewRoot(ctx, n.DAG, newDirNode(), nil)
+	if err := checkNode(n); err != nil {
+		return nil, err
+	}
+	return newAdder(ctx, n, n.Blockstore, n.Exchange, out)
+}
+
+// NewLocalAdder returns an Adder that never touches the network, even on an
+// online node. Its blocks are written to the node's blockstore without being
+// announced to bitswap or provided to the routing system, and every read it
+// does (building directories, --verify) is served from the blockstore alone.
+// Pins are still flushed by the node's pinner, which writes the pinset's
+// internal blocks through the node's (possibly online) DAG service.
+func NewLocalAdder(ctx context.Context, n *core.IpfsNode, out chan interface{}) (*Adder, error) {
+	if err := checkNode(n); err != nil {
+		return nil, err
+	}
+	return newAdder(ctx, n, n.Blockstore, offline.Exchange(n.Blockstore), out)
+}
+
+// checkNode returns an error if n lacks any of the services an add needs,
+// as happens with an IpfsNode that was not built by core.NewNode, or not
+// fully set up yet.
+func checkNode(n *core.IpfsNode) error {
+	switch {
+	case n == nil:
+		return errors.New("cannot add: node is nil")
+	case n.Blockstore == nil:
+		return errors.New("cannot add: node has no blockstore, it must be built with core.NewNode")
+	case n.DAG == nil:
+		return errors.New("cannot add: node has no DAG service, it must be built with core.NewNode")
+	case n.Pinning == nil:
+		return errors.New("cannot add: node has no pinner, it must be built with core.NewNode")
+	}
+	return nil
+}
+
+// newAdder returns an Adder writing to bs, and announcing the blocks written
+// to exch. The writes keep to n's IOLimit.
+func newAdder(ctx context.Context, n *core.IpfsNode, bs bstore.Blockstore, exch exchange.Interface, out chan interface{}) (*Adder, error) {
+	if n.IOLimit != nil {
+		bs = &limitedBlockstore{Blockstore: bs, ctx: ctx, limit: n.IOLimit}
+	}
+	stats := &statsBlockstore{Blockstore: bs}
+	ds := dag.NewDAGService(bserv.New(stats, exch))
+	mr, err := mfs.NewRoot(ctx, ds, newDirNode(), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Adder{
+	adder := &Adder{
 		mr:       mr,
 		ctx:      ctx,
 		node:     n,
+		dagserv:  ds,
 		out:      out,
 		Progress: false,
 		Hidden:   true,
 		Pin:      true,
+		Flush:    true,
 		Trickle:  false,
 		Wrap:     false,
 		Chunker:  "",
-	}, nil
+		seen:     make(map[string]string),
+		summary:  newAddSummary(),
+		// both are added to by the workers of RecursiveWorkers at once
+		bytesRead: new(int64),
+		totalRead: new(int64),
+	}
+	stats.adder = adder
+	return adder, nil
 }
 
 // Internal structure for holding the switches passed to the `add` call
 type Adder struct {
-	ctx      context.Context
-	node     *core.IpfsNode
-	out      chan interface{}
-	Progress bool
-	Hidden   bool
-	Pin      bool
-	Trickle  bool
-	Silent   bool
-	Wrap     bool
-	Chunker  string
-	root     *dag.Node
-	mr       *mfs.Root
-	unlocker bs.Unlocker
-	tempRoot key.Key
+	ctx              context.Context
+	node             *core.IpfsNode
+	dagserv          dag.DAGService
+	out              chan interface{}
+	Progress         bool
+	Hidden           bool
+	HiddenFilesOnly  bool // with Hidden unset, still add hidden regular files
+	Pin              bool
+	PinRootOnly      bool // pin the root directly, rather than the DAG recursively
+	Flush            bool // flush pins to the datastore before returning
+	Trickle          bool
+	Silent           bool
+	Wrap             bool
+	Chunker          string
+	RawLeaves        bool
+	RawLeafMax       int
+	Include          []string
+	Exclude          []string
+	RejectTypes      []string
+	PinRules         []PinRule
+	AppendTo         *dag.Node
+	RenameDuplicates bool
+	NormalizeNames   bool   // treat backslashes in names as separators, see normalizeName
+	Checksum         string // checksum algorithm for added files, none if empty
+	MaxLinks         int    // fan-out of intermediate file nodes, default if zero
+	Journal          *Journal
+	NameIndex        *NameIndex
+	StreamManifest   *StreamManifest
+	OnProgress       ProgressFunc
+	Stats            bool   // send an AddSummary when the add is finalized
+	Metadata         []byte // stored next to the added root, see wrapRoot
+	Cover            []byte // a preview stored next to the added root, see wrapRoot
+	CoverName        string // link name of the Cover, DefaultCoverLink if empty
+	MaxDirEntries    int    // DefaultMaxDirEntries if zero
+	WriteRetries     int    // times to retry a failed block write of a file
+	Transform        string // content transform applied to files, see NewTransform
+	MaxDepth         int    // levels of directories to descend, unlimited if zero
+	MaxTotal         int64  // bytes to read from all files, unlimited if zero
+	ReaderBuffer     int    // size of the read buffer of each file, unbuffered if zero
+	UnixfsType       string // type of the leaves of files, see ValidateUnixfsType
+	Split            int64  // add each file as a directory of parts this big, if set
+	OnTruncate       string // what to do with a file that shrank or vanished while read, unchecked if empty
+	OffsetIndex      bool   // link an index of the file's blocks next to the root, see wrapRoot
+	RecursiveWorkers int    // local files imported at once, see importAsync
+	root             *dag.Node
+	mr               *mfs.Root
+	unlocker         bs.Unlocker
+	tempRoot         key.Key
+	unflushed        bool
+	seen             map[string]string // entry path -> source of the entry
+	renamed          *[2]string        // active top-level rename, old and new path
+	summary          *AddSummary
+	presence         *presenceBlockstore
+	bytesRead        *int64       // by progressReaders, over the whole add
+	totalRead        *int64       // by limitReaders, over the whole add
+	jobs             []*importJob // imports of RecursiveWorkers, in walk order
+	depth            int          // of the directory addDir is in, 1 at the top
 }
 
 // Perform the actual add & pin locally, outputting results to reader
 func (adder Adder) add(reader io.Reader) (*dag.Node, error) {
+	nd, _, _, err := adder.layout(reader)
+	return nd, err
+}
+
+// layout imports reader into a DAG, and also returns the number of data
+// blocks it was split into and the depth of the resulting DAG.
+func (adder Adder) layout(reader io.Reader) (*dag.Node, int64, int, error) {
 	chnk, err := chunk.FromString(reader, adder.Chunker)
 	if err != nil {
-		return nil, err
+		return nil, 0, 0, err
+	}
+	return adder.layoutChunks(chnk)
+}
+
+// layoutChunks is like layout, for data already split into chunks.
+func (adder Adder) layoutChunks(chnk chunk.Splitter) (*dag.Node, int64, int, error) {
+	var err error
+	var dserv dag.DAGService = adder.dagserv
+	if adder.WriteRetries > 0 {
+		// only the writes are retried, the reader is consumed once
+		dserv = newRetryDAGService(dserv, adder.WriteRetries)
+	}
+
+	dbp := h.DagBuilderParams{
+		Dagserv:        dserv,
+		Maxlinks:       h.DefaultLinksPerBlock,
+		RawLeaves:      adder.RawLeaves,
+		RawLeafMaxSize: adder.RawLeafMax,
+	}
+	if adder.MaxLinks > 0 {
+		dbp.Maxlinks = adder.MaxLinks
+	}
+	if adder.UnixfsType != "" {
+		if err := ValidateUnixfsType(adder.UnixfsType); err != nil {
+			return nil, 0, 0, err
+		}
+		// a file of a single block is its own leaf, and has the type too
+		switch unixfsTypes[adder.UnixfsType] {
+		case unixfs.TRaw:
+			dbp.RawLeaves = true
+			dbp.RawLeafMaxSize = 0
+		case unixfs.TFile:
+			dbp.RawLeaves = false
+			dbp.FileLeaves = true
+		}
 	}
 
-	if adder.Trickle {
-		return importer.BuildTrickleDagFromReader(
-			adder.node.DAG,
-			chnk,
-		)
+	db := dbp.New(chnk)
+	var nd *dag.Node
+	if adder.AppendTo != nil {
+		nd, err = adder.appendLayout(db, dserv)
+	} else if adder.Trickle {
+		nd, err = trickle.TrickleLayout(db)
+	} else {
+		nd, err = balanced.BalancedLayout(db)
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	blocks, depth := db.Shape()
+	return nd, blocks, depth, nil
+}
+
+// appendLayout extends the trickle dag of AppendTo with the data of db. The
+// new root shares all the blocks of AppendTo but its root and right edge.
+func (adder Adder) appendLayout(db *h.DagBuilderHelper, dserv dag.DAGService) (*dag.Node, error) {
+	if err := trickle.VerifyTrickleDag(adder.AppendTo, adder.dagserv, db.Maxlinks()); err != nil {
+		return nil, fmt.Errorf("cannot append, the file is not a trickle dag: %s", err)
+	}
+
+	// unlike the layouts, TrickleAppend leaves storing the root to us
+	nd, err := trickle.TrickleAppend(adder.ctx, adder.AppendTo.Copy(), db)
+	if err != nil {
+		return nil, err
 	}
-	return importer.BuildDagFromReader(
-		adder.node.DAG,
-		chnk,
-	)
+	if _, err := dserv.Add(nd); err != nil {
+		return nil, err
+	}
+	return nd, nil
 }
 
 func (adder *Adder) RootNode() (*dag.Node, error) {
@@ -137,7 +336,7 @@ func (adder *Adder) RootNode() (*dag.Node, error) {
 
 	// if not wrapping, AND one root file, use that hash as root.
 	if !adder.Wrap && len(root.Links) == 1 {
-		root, err = root.Links[0].GetNode(adder.ctx, adder.node.DAG)
+		root, err = root.Links[0].GetNode(adder.ctx, adder.dagserv)
 		if err != nil {
 			return nil, err
 		}
@@ -147,16 +346,27 @@ func (adder *Adder) RootNode() (*dag.Node, error) {
 	return root, err
 }
 
+// PinRoot pins the root of the added DAG and releases the pin lock taken by
+// AddFile. The root is the only object pinned: its recursive pin keeps every
+// block below it from being collected, so the files under it, including the
+// inputs wrapped by Wrap, get no pins of their own. The pins of PinRules,
+// taken as files are added, are flushed here instead, once for the add.
 func (adder *Adder) PinRoot() error {
+	defer adder.Close()
+
 	root, err := adder.RootNode()
 	if err != nil {
 		return err
 	}
 	if !adder.Pin {
+		if adder.unflushed {
+			adder.unflushed = false
+			return adder.flushPins()
+		}
 		return nil
 	}
 
-	rnk, err := adder.node.DAG.Add(root)
+	rnk, err := adder.dagserv.Add(root)
 	if err != nil {
 		return err
 	}
@@ -166,13 +376,87 @@ func (adder *Adder) PinRoot() error {
 		if err != nil {
 			return err
 		}
-		adder.tempRoot = rnk
+		adder.tempRoot = ""
 	}
 
-	adder.node.Pinning.PinWithMode(rnk, pin.Recursive)
+	mode := pin.Recursive
+	if adder.PinRootOnly {
+		// protects the root only; the rest stays collectable until the
+		// DAG is pinned recursively
+		mode = pin.Direct
+	}
+	adder.node.Pinning.PinWithMode(rnk, mode)
+	adder.unflushed = false
+	return adder.flushPins()
+}
+
+// flushPins writes the pinset out, or, if Flush is false, leaves that to the
+// node, which flushes deferred pins on 'ipfs repo flush' and on shutdown.
+func (adder *Adder) flushPins() error {
+	if !adder.Flush {
+		adder.node.DeferPinFlush()
+		return nil
+	}
 	return adder.node.Pinning.Flush()
 }
 
+// pinTempRoot pins the current, partial root of the add so that its blocks
+// survive a GC run that happens before the add completes. GC reads the
+// in-memory pinset, so the pin is not flushed here: PinRoot replaces it with
+// the pin of the final root, and Close drops it if the add never gets there.
+func (adder *Adder) pinTempRoot() error {
+	if !adder.Pin {
+		return nil
+	}
+
+	root, err := adder.mr.GetValue().GetNode()
+	if err != nil {
+		return err
+	}
+
+	rnk, err := adder.dagserv.Add(root)
+	if err != nil {
+		return err
+	}
+
+	if adder.tempRoot != "" {
+		err := adder.node.Pinning.Unpin(adder.ctx, adder.tempRoot, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	adder.node.Pinning.PinWithMode(rnk, pin.Recursive)
+	adder.tempRoot = rnk
+	adder.unflushed = true
+	return nil
+}
+
+// Close flushes any pins not yet written out and releases the pin lock held
+// by the adder, if any. The pin of a partial root taken by pinTempRoot is
+// dropped first, so an add that fails or is aborted leaves no pin on a
+// partial DAG. It is safe to call more than once.
+func (adder *Adder) Close() error {
+	var err error
+	if adder.tempRoot != "" {
+		err = adder.node.Pinning.Unpin(adder.ctx, adder.tempRoot, true)
+		adder.tempRoot = ""
+	}
+
+	if adder.unflushed {
+		if ferr := adder.flushPins(); err == nil {
+			err = ferr
+		}
+		adder.unflushed = false
+	}
+
+	if adder.unlocker != nil {
+		adder.unlocker.Unlock()
+		adder.unlocker = nil
+	}
+	return err
+}
+
 func (adder *Adder) Finalize() (*dag.Node, error) {
 	// cant just call adder.RootNode() here as we need the name for printing
 	root, err := adder.mr.GetValue().GetNode()
@@ -183,7 +467,7 @@ func (adder *Adder) Finalize() (*dag.Node, error) {
 	var name string
 	if !adder.Wrap {
 		name = root.Links[0].Name
-		child, err := root.Links[0].GetNode(adder.ctx, adder.node.DAG)
+		child, err := root.Links[0].GetNode(adder.ctx, adder.dagserv)
 		if err != nil {
 			return nil, err
 		}
@@ -195,6 +479,24 @@ func (adder *Adder) Finalize() (*dag.Node, error) {
 		return nil, err
 	}
 
+	if adder.Metadata != nil || adder.Cover != nil || adder.OffsetIndex {
+		root, err = adder.wrapRoot(root)
+		if err != nil {
+			return nil, err
+		}
+		adder.root = root
+
+		if !adder.Silent {
+			if err := outputDagnode(adder.out, "", root, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if adder.Stats && adder.out != nil {
+		adder.out <- &AddedObject{Summary: adder.summary}
+	}
+
 	err = adder.mr.Close()
 	if err != nil {
 		return nil, err
@@ -203,13 +505,125 @@ func (adder *Adder) Finalize() (*dag.Node, error) {
 	return root, nil
 }
 
+// Names of the links of the directory wrapRoot puts around a root.
+const (
+	MetadataDataLink = "data"
+	MetadataMetaLink = "meta"
+	DefaultCoverLink = "cover"
+	OffsetIndexLink  = "offsets"
+)
+
+// wrapRoot returns a directory linking to root as MetadataDataLink, to a
+// unixfs file holding the adder's Metadata as MetadataMetaLink, to a unixfs
+// file of the adder's Cover as CoverName, each if set, and with OffsetIndex,
+// to a unixfs file of the offset index of root as OffsetIndexLink. That
+// directory becomes the root of the add, and is the one pinned.
+func (adder *Adder) wrapRoot(root *dag.Node) (*dag.Node, error) {
+	if _, err := adder.dagserv.Add(root); err != nil {
+		return nil, err
+	}
+
+	wrapper := newDirNode()
+	if err := wrapper.AddNodeLink(MetadataDataLink, root); err != nil {
+		return nil, err
+	}
+
+	if adder.Metadata != nil {
+		meta, err := adder.add(bytes.NewReader(adder.Metadata))
+		if err != nil {
+			return nil, err
+		}
+		if err := wrapper.AddNodeLink(MetadataMetaLink, meta); err != nil {
+			return nil, err
+		}
+	}
+
+	if adder.Cover != nil {
+		name := adder.CoverName
+		if name == "" {
+			name = DefaultCoverLink
+		}
+		cover, err := adder.add(bytes.NewReader(adder.Cover))
+		if err != nil {
+			return nil, err
+		}
+		if err := wrapper.AddNodeLink(name, cover); err != nil {
+			return nil, err
+		}
+	}
+
+	if adder.OffsetIndex {
+		index, err := BuildOffsetIndex(adder.ctx, adder.dagserv, root)
+		if err != nil {
+			return nil, err
+		}
+		nd, err := adder.add(bytes.NewReader(index))
+		if err != nil {
+			return nil, err
+		}
+		if err := wrapper.AddNodeLink(OffsetIndexLink, nd); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := adder.dagserv.Add(wrapper); err != nil {
+		return nil, err
+	}
+	return wrapper, nil
+}
+
+// Verify reads back every block of the added DAG from the local blockstore,
+// ensuring each one is present and matches its key.
+func (adder *Adder) Verify() error {
+	root, err := adder.RootNode()
+	if err != nil {
+		return err
+	}
+
+	k, err := root.Key()
+	if err != nil {
+		return err
+	}
+
+	return verifyBlocks(adder.node.Blockstore, k, key.NewKeySet())
+}
+
+func verifyBlocks(bs bstore.Blockstore, k key.Key, seen key.KeySet) error {
+	if seen.Has(k) {
+		return nil
+	}
+	seen.Add(k)
+
+	b, err := bs.Get(k)
+	if err != nil {
+		return fmt.Errorf("verify: could not read block %s: %s", k, err)
+	}
+
+	if blocks.NewBlock(b.Data).Key() != k {
+		return fmt.Errorf("verify: block %s is corrupt", k)
+	}
+
+	nd, err := dag.DecodeProtobuf(b.Data)
+	if err != nil {
+		return fmt.Errorf("verify: could not decode block %s: %s", k, err)
+	}
+
+	for _, l := range nd.Links {
+		if err := verifyBlocks(bs, key.Key(l.Hash), seen); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (adder *Adder) outputDirs(path string, nd *dag.Node) error {
 	if !bytes.Equal(nd.Data, folderData) {
 		return nil
 	}
 
 	for _, l := range nd.Links {
-		child, err := l.GetNode(adder.ctx, adder.node.DAG)
+		child, err := l.GetNode(adder.ctx, adder.dagserv)
 		if err != nil {
 			return err
 		}
@@ -220,7 +634,8 @@ func (adder *Adder) outputDirs(path string, nd *dag.Node) error {
 		}
 	}
 
-	return outputDagnode(adder.out, path, nd)
+	// directories get no checksum, only the files in them do
+	return outputDagnode(adder.out, path, nd, nil)
 }
 
 // Add builds a merkledag from the a reader, pinning all objects to the local
@@ -247,7 +662,7 @@ func Add(n *core.IpfsNode, r io.Reader) (string, error) {
 
 // AddR recursively adds files in |path|.
 func AddR(n *core.IpfsNode, root string) (key string, err error) {
-	n.Blockstore.PinLock().Unlock()
+	defer n.Blockstore.PinLock().Unlock()
 
 	stat, err := os.Lstat(root)
 	if err != nil {
@@ -315,7 +730,9 @@ func AddWrapped(n *core.IpfsNode, r io.Reader, filename string) (string, *dag.No
 	return gopath.Join(k.String(), filename), dagnode, nil
 }
 
-func (adder *Adder) addNode(node *dag.Node, path string) error {
+// addNode patches node into the root at path and reports it. info holds the
+// file details to report along with it, if any.
+func (adder *Adder) addNode(node *dag.Node, path string, info *AddedObject) error {
 	// patch it into the root
 	if path == "" {
 		key, err := node.Key()
@@ -337,20 +754,88 @@ func (adder *Adder) addNode(node *dag.Node, path string) error {
 		return err
 	}
 
+	if adder.NameIndex != nil {
+		k, err := node.Key()
+		if err != nil {
+			return err
+		}
+		if err := adder.NameIndex.record(path, k); err != nil {
+			return err
+		}
+	}
+
 	if !adder.Silent {
-		return outputDagnode(adder.out, path, node)
+		return outputDagnode(adder.out, path, node, info)
 	}
 	return nil
 }
 
-// Add the given file while respecting the adder.
+// Add the given file while respecting the adder. The blockstore pin lock is
+// taken on the first call and held until PinRoot or Close, so that GC can not
+// collect the added blocks before they are pinned. All of the file is added
+// when AddFile returns, including the files imported by RecursiveWorkers.
 func (adder *Adder) AddFile(file files.File) error {
-	adder.unlocker = adder.node.Blockstore.PinLock()
-	defer func() {
-		adder.unlocker.Unlock()
-	}()
+	if adder.unlocker == nil {
+		adder.unlocker = adder.node.Blockstore.PinLock()
+	}
+
+	if err := adder.addFile(file); err != nil {
+		adder.abortImports()
+		return err
+	}
+	return adder.waitImports()
+}
+
+// AddManifest adds a directory tree described by a manifest, where each line
+// holds a path and the hash of an object already in the local blockstore,
+// separated by a tab. No data is read or hashed; the tree simply links to the
+// referenced objects. Blank lines and lines starting with '#' are ignored.
+func (adder *Adder) AddManifest(r io.Reader) error {
+	if adder.unlocker == nil {
+		adder.unlocker = adder.node.Blockstore.PinLock()
+	}
+
+	scan := bufio.NewScanner(r)
+	for line := 1; scan.Scan(); line++ {
+		text := strings.TrimSpace(scan.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.Split(text, "\t")
+		if len(parts) != 2 {
+			return fmt.Errorf("manifest line %d: expected '<path><TAB><hash>'", line)
+		}
+
+		p := gopath.Clean(strings.TrimPrefix(parts[0], "/"))
+		if p == "." || strings.HasPrefix(p, "../") {
+			return fmt.Errorf("manifest line %d: invalid path %q", line, parts[0])
+		}
+
+		k := key.B58KeyDecode(parts[1])
+		if k == "" {
+			return fmt.Errorf("manifest line %d: invalid hash %q", line, parts[1])
+		}
 
-	return adder.addFile(file)
+		has, err := adder.node.Blockstore.Has(k)
+		if err != nil {
+			return err
+		}
+		if !has {
+			return fmt.Errorf("manifest line %d: %s is not in the local blockstore", line, k)
+		}
+
+		nd, err := adder.dagserv.Get(adder.ctx, k)
+		if err != nil {
+			return err
+		}
+
+		if err := adder.addNode(nd, p, nil); err != nil {
+			return fmt.Errorf("manifest line %d: %s", line, err)
+		}
+	}
+
+	return scan.Err()
 }
 
 func (adder *Adder) addFile(file files.File) error {
@@ -359,51 +844,354 @@ func (adder *Adder) addFile(file files.File) error {
 		return err
 	}
 
+	path, err := adder.entryPath(file)
+	if err != nil {
+		return err
+	}
+
 	if file.IsDirectory() {
-		return adder.addDir(file)
+		return adder.addDir(file, path)
+	}
+
+	if adder.presence != nil {
+		adder.presence.missing = false
 	}
 
 	// case for symlink
 	if s, ok := file.(*files.Symlink); ok {
+		// linked right away, so after the files still being imported
+		if err := adder.waitImports(); err != nil {
+			return err
+		}
+
 		sdata, err := unixfs.SymlinkData(s.Target)
 		if err != nil {
 			return err
 		}
 
 		dagnode := &dag.Node{Data: sdata}
-		_, err = adder.node.DAG.Add(dagnode)
+		k, err := adder.dagserv.Add(dagnode)
 		if err != nil {
 			return err
 		}
 
-		return adder.addNode(dagnode, s.FileName())
+		var info *AddedObject
+		if adder.presence != nil {
+			info = &AddedObject{Present: !adder.presence.missing}
+		}
+		if adder.PinRules != nil {
+			if info == nil {
+				info = &AddedObject{}
+			}
+			info.PinMode = adder.pinByRules(file, k)
+		}
+		return adder.addNode(dagnode, path, info)
 	}
 
-	// case for regular file
-	// if the progress flag was specified, wrap the file so that we can send
-	// progress updates to the client (over the output channel)
+	// case for regular file; unnamed entries, such as stdin, can't be told
+	// apart between runs, so they are never journaled
+	if adder.Journal != nil && path != "" {
+		if done, err := adder.addJournaled(path); done || err != nil {
+			return err
+		}
+	}
+
+	// with RecursiveWorkers, a local file is imported by a worker while the
+	// walk goes on, reading the file through a descriptor of its own, as a
+	// directory closes the file it yielded when it moves on to the next
 	var reader io.Reader = file
-	if adder.Progress {
-		reader = &progressReader{file: file, out: adder.out}
+	var local *os.File
+	if adder.RecursiveWorkers > 1 && adder.presence == nil {
+		local = openLocal(file)
+	}
+	if local != nil {
+		// closed here unless handed to a worker
+		defer func() {
+			if local != nil {
+				local.Close()
+			}
+		}()
+		reader = local
+	}
+
+	// buffering sits right on the file, so that everything else counts the
+	// bytes the importer actually consumed
+	var trunc *truncateReader
+	if adder.OnTruncate != "" {
+		trunc = newTruncateReader(reader, file, path)
+		reader = trunc
+	}
+	if adder.ReaderBuffer > 0 {
+		reader = bufio.NewReaderSize(reader, adder.ReaderBuffer)
+	}
+
+	// the type is sniffed from the file as given, and the sniffed bytes are
+	// read again by all that follows
+	if len(adder.RejectTypes) > 0 {
+		typ, r, err := sniffType(reader)
+		if err != nil {
+			return err
+		}
+		if adder.rejectsType(typ) {
+			return &RejectedTypeError{Path: path, Type: typ}
+		}
+		reader = r
+	}
+
+	// the limit counts the bytes of the files as given, before any transform
+	if adder.MaxTotal > 0 {
+		reader = &limitReader{r: reader, total: adder.totalRead, max: adder.MaxTotal}
+	}
+
+	// a transform changes the bytes added, and so the resulting hash; all
+	// that follows, progress included, sees the transformed bytes
+	if adder.Transform != "" {
+		transform, err := NewTransform(adder.Transform)
+		if err != nil {
+			return err
+		}
+		reader = transform(reader)
+	}
+
+	// if the progress flag was specified, wrap the file so that we can send
+	// progress updates to the client (over the output channel), and to
+	// OnProgress, if set
+	if adder.Progress || adder.OnProgress != nil {
+		pr := &progressReader{file: file, r: reader, fn: adder.OnProgress, total: adder.bytesRead}
+		if adder.Progress {
+			pr.out = adder.out
+		}
+		reader = pr
+	}
+
+	var counter *countingReader
+	if adder.Stats {
+		counter = &countingReader{r: reader}
+		reader = counter
+	}
+
+	// hash the raw file as it streams into the importer, so that the file
+	// is read only once
+	var sum hash.Hash
+	if adder.Checksum != "" {
+		sum, err = NewChecksum(adder.Checksum)
+		if err != nil {
+			return err
+		}
+		reader = io.TeeReader(reader, sum)
+	}
+
+	layout := adder.layout
+	if adder.Split > 0 {
+		layout = adder.splitLayout
+	}
+	// links the file in once imported, and reports it
+	link := func(dagnode *dag.Node, blocks int64, depth int) error {
+		if trunc != nil && trunc.err != nil {
+			switch adder.OnTruncate {
+			case TruncateSkip:
+				log.Warningf("%s, skipping", trunc.err)
+				return nil
+			case TruncatePartial:
+				log.Warningf("%s, adding the bytes read", trunc.err)
+			default:
+				return trunc.err
+			}
+		}
+
+		info := &AddedObject{Blocks: blocks, Depth: depth}
+		if adder.presence != nil {
+			info.Present = !adder.presence.missing
+		}
+		if sum != nil {
+			info.Checksum = hex.EncodeToString(sum.Sum(nil))
+		}
+		if counter != nil {
+			adder.countFile(counter.n)
+		}
+		if adder.PinRules != nil {
+			k, err := dagnode.Key()
+			if err != nil {
+				return err
+			}
+			info.PinMode = adder.pinByRules(file, k)
+		}
+
+		if adder.StreamManifest != nil {
+			if err := adder.StreamManifest.record(adder.ctx, adder.dagserv, path, dagnode); err != nil {
+				return err
+			}
+		}
+
+		if adder.Journal != nil && path != "" {
+			k, err := dagnode.Key()
+			if err != nil {
+				return err
+			}
+			if err := adder.Journal.Record(path, k); err != nil {
+				return err
+			}
+		}
+
+		// patch it into the root
+		return adder.addNode(dagnode, path, info)
+	}
+
+	if local != nil {
+		f := local
+		local = nil
+		return adder.importAsync(f, reader, layout, link)
 	}
 
-	dagnode, err := adder.add(reader)
+	dagnode, blocks, depth, err := layout(reader)
 	if err != nil {
 		return err
 	}
+	// linked after the files still being imported
+	if err := adder.waitImports(); err != nil {
+		return err
+	}
+	return link(dagnode, blocks, depth)
+}
 
-	// patch it into the root
-	return adder.addNode(dagnode, file.FileName())
+// countFile records a regular file of the given size in the add's summary.
+func (adder *Adder) countFile(size int64) {
+	adder.summary.addFile(size)
+}
+
+// countBlock records a block written in the add's summary, and whether the
+// blockstore already had it.
+func (adder *Adder) countBlock(size int, existed bool) {
+	adder.summary.addBlock(size, existed)
+}
+
+// addJournaled adds the file at path from the journal of an earlier run,
+// without reading it again. It returns false if the file has to be added
+// normally: it isn't journaled, or its blocks have since been removed, e.g.
+// by a GC between the runs.
+func (adder *Adder) addJournaled(path string) (bool, error) {
+	k, ok := adder.Journal.Lookup(path)
+	if !ok {
+		return false, nil
+	}
+
+	has, err := adder.node.Blockstore.Has(k)
+	if err != nil || !has {
+		return false, err
+	}
+
+	nd, err := adder.dagserv.Get(adder.ctx, k)
+	if err != nil {
+		return false, err
+	}
+	// linked right away, so after the files still being imported
+	if err := adder.waitImports(); err != nil {
+		return false, err
+	}
+	log.Infof("%s was added by an earlier run, skipping", path)
+	if adder.Stats {
+		size, err := unixfs.DataSize(nd.Data)
+		if err != nil {
+			return false, err
+		}
+		adder.countFile(int64(size))
+	}
+	if adder.StreamManifest != nil {
+		if err := adder.StreamManifest.record(adder.ctx, adder.dagserv, path, nd); err != nil {
+			return false, err
+		}
+	}
+	return true, adder.addNode(nd, path, nil)
+}
+
+// entryPath returns the path under which file is added, and checks that no
+// earlier file was added under the same path, which would otherwise shadow
+// or merge with it. Duplicate top-level entries are renamed when
+// RenameDuplicates is set, and their children are moved along with them.
+func (adder *Adder) entryPath(file files.File) (string, error) {
+	p := file.FileName()
+	if p == "" {
+		// unnamed entries are added under their hash
+		return p, nil
+	}
+	if adder.NormalizeNames {
+		p = normalizeName(p)
+	}
+
+	top := !strings.Contains(p, "/")
+	if top {
+		adder.renamed = nil
+	} else if r := adder.renamed; r != nil && strings.HasPrefix(p, r[0]+"/") {
+		p = r[1] + strings.TrimPrefix(p, r[0])
+	}
+
+	src := file.FullPath()
+	if src == "" {
+		src = file.FileName()
+	}
+
+	if prev, ok := adder.seen[p]; ok {
+		if !adder.RenameDuplicates || !top {
+			return "", fmt.Errorf("duplicate entry name %q: %s conflicts with %s", p, src, prev)
+		}
+
+		ext := gopath.Ext(p)
+		base := strings.TrimSuffix(p, ext)
+		np := p
+		for i := 1; ; i++ {
+			np = fmt.Sprintf("%s-%d%s", base, i, ext)
+			if _, ok := adder.seen[np]; !ok {
+				break
+			}
+		}
+		log.Infof("renaming duplicate entry %s to %s", p, np)
+		adder.renamed = &[2]string{p, np}
+		p = np
+	}
+
+	adder.seen[p] = src
+	return p, nil
+}
+
+// normalizeName turns the backslash separators of a name given on Windows
+// into slashes, so that the same tree added on any platform gives the same
+// links, and so the same root.
+func normalizeName(name string) string {
+	return gopath.Clean(strings.Replace(name, `\`, "/", -1))
 }
 
-func (adder *Adder) addDir(dir files.File) error {
-	log.Infof("adding directory: %s", dir.FileName())
+func (adder *Adder) addDir(dir files.File, path string) error {
+	log.Infof("adding directory: %s", path)
 
-	err := mfs.Mkdir(adder.mr, dir.FileName(), true, false)
+	adder.depth++
+	defer func() { adder.depth-- }()
+	if adder.MaxDepth > 0 && adder.depth > adder.MaxDepth {
+		return &DepthLimitError{Path: path}
+	}
+
+	err := mfs.Mkdir(adder.mr, path, true, false)
 	if err != nil {
 		return err
 	}
 
+	if adder.Progress && adder.out != nil {
+		// a byte-less progress event, so that clients see the walk advance
+		// through trees of many small files
+		adder.out <- &AddedObject{
+			Name:  dir.FileName(),
+			Total: atomic.LoadInt64(adder.bytesRead),
+		}
+	}
+
+	maxEntries := adder.MaxDirEntries
+	if maxEntries == 0 {
+		maxEntries = DefaultMaxDirEntries
+	}
+
+	// Entries are added in the order NextFile yields them, which depends on
+	// the filesystem. That doesn't change the resulting directory node, as
+	// links are sorted by name when it is encoded.
+	var entries int
 	for {
 		file, err := dir.NextFile()
 		if err != nil && err != io.EOF {
@@ -414,10 +1202,20 @@ func (adder *Adder) addDir(dir files.File) error {
 		}
 
 		// Skip hidden files when adding recursively, unless Hidden is enabled.
-		if files.IsHidden(file) && !adder.Hidden {
+		if adder.skipHidden(file) {
 			log.Infof("%s is hidden, skipping", file.FileName())
 			continue
 		}
+
+		if err := adder.filterFile(file); err != nil {
+			log.Infof("%s, skipping", err)
+			continue
+		}
+
+		entries++
+		if entries > maxEntries {
+			return fmt.Errorf("directory %s has more than %d entries, the maximum for a single directory node", dir.FileName(), maxEntries)
+		}
 		err = adder.addFile(file)
 		if err != nil {
 			return err
@@ -427,9 +1225,53 @@ func (adder *Adder) addDir(dir files.File) error {
 	return nil
 }
 
+// skipHidden reports whether file is hidden and must be skipped. With
+// HiddenFilesOnly, only hidden directories (such as .git) are skipped.
+func (adder *Adder) skipHidden(file files.File) bool {
+	if adder.Hidden || !files.IsHidden(file) {
+		return false
+	}
+	return !adder.HiddenFilesOnly || file.IsDirectory()
+}
+
+// filterFile returns an ignoreFileError if file is excluded by the adder's
+// Include and Exclude patterns. Patterns are matched against the path relative
+// to the directory being added. Exclude patterns apply to directories too,
+// while Include patterns only select regular files.
+func (adder *Adder) filterFile(file files.File) error {
+	rel := relativeName(file.FileName())
+	for _, p := range adder.Exclude {
+		if matchGlob(p, rel) {
+			return &ignoreFileError{file.FileName()}
+		}
+	}
+
+	if len(adder.Include) == 0 || file.IsDirectory() {
+		return nil
+	}
+
+	for _, p := range adder.Include {
+		if matchGlob(p, rel) {
+			return nil
+		}
+	}
+	return &ignoreFileError{file.FileName()}
+}
+
 func (adder *Adder) maybePauseForGC() error {
+	// callers that hold the pin lock themselves can't pause
+	if adder.unlocker == nil {
+		return nil
+	}
+
 	if adder.node.Blockstore.GCRequested() {
-		err := adder.PinRoot()
+		// the blocks of files still being imported are not under the
+		// root yet
+		if err := adder.waitImports(); err != nil {
+			return err
+		}
+
+		err := adder.pinTempRoot()
 		if err != nil {
 			return err
 		}
@@ -440,8 +1282,9 @@ func (adder *Adder) maybePauseForGC() error {
 	return nil
 }
 
-// outputDagnode sends dagnode info over the output channel
-func outputDagnode(out chan interface{}, name string, dn *dag.Node) error {
+// outputDagnode sends dagnode info over the output channel, along with the
+// file details in info, if any.
+func outputDagnode(out chan interface{}, name string, dn *dag.Node, info *AddedObject) error {
 	if out == nil {
 		return nil
 	}
@@ -451,10 +1294,13 @@ func outputDagnode(out chan interface{}, name string, dn *dag.Node) error {
 		return err
 	}
 
-	out <- &AddedObject{
-		Hash: o.Hash,
-		Name: name,
+	obj := &AddedObject{}
+	if info != nil {
+		*obj = *info
 	}
+	obj.Hash = o.Hash
+	obj.Name = name
+	out <- obj
 
 	return nil
 }
@@ -494,22 +1340,54 @@ func getOutput(dagnode *dag.Node) (*Object, error) {
 	return output, nil
 }
 
+// limitReader fails once more than max bytes were read from all the files of
+// an add. The add then stops before its root is pinned, so the blocks written
+// are left for GC.
+type limitReader struct {
+	r     io.Reader
+	total *int64 // bytes read from all files of the add
+	max   int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if atomic.AddInt64(l.total, int64(n)) > l.max {
+		return n, fmt.Errorf("add exceeds the limit of %d bytes in total", l.max)
+	}
+	return n, err
+}
+
+// ProgressFunc is called as an Adder reads each file, with the file's name and
+// the number of its bytes read so far, see Adder.OnProgress. With
+// RecursiveWorkers, it is called for several files at once.
+type ProgressFunc func(name string, bytes int64)
+
 type progressReader struct {
 	file         files.File
+	r            io.Reader // reads file, possibly transformed
 	out          chan interface{}
+	fn           ProgressFunc
 	bytes        int64
 	lastProgress int64
+	total        *int64 // bytes read from all files of the add
 }
 
 func (i *progressReader) Read(p []byte) (int, error) {
-	n, err := i.file.Read(p)
+	n, err := i.r.Read(p)
 
 	i.bytes += int64(n)
+	total := atomic.AddInt64(i.total, int64(n))
 	if i.bytes-i.lastProgress >= progressReaderIncrement || err == io.EOF {
 		i.lastProgress = i.bytes
-		i.out <- &AddedObject{
-			Name:  i.file.FileName(),
-			Bytes: i.bytes,
+		if i.out != nil {
+			i.out <- &AddedObject{
+				Name:  i.file.FileName(),
+				Bytes: i.bytes,
+				Total: total,
+			}
+		}
+		if i.fn != nil {
+			i.fn(i.file.FileName(), i.bytes)
 		}
 	}
 
@@ -2,8 +2,16 @@ package coreunix
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,10 +23,12 @@ import (
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/repo/config"
 	"github.com/ipfs/go-ipfs/thirdparty/testutil"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	"gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
 )
 
-func TestAddRecursive(t *testing.T) {
+func newTestNode(t *testing.T) *core.IpfsNode {
 	r := &repo.Mock{
 		C: config.Config{
 			Identity: config.Identity{
@@ -27,10 +37,15 @@ func TestAddRecursive(t *testing.T) {
 		},
 		D: testutil.ThreadSafeCloserMapDatastore(),
 	}
-	node, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	n, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
 	if err != nil {
 		t.Fatal(err)
 	}
+	return n
+}
+
+func TestAddRecursive(t *testing.T) {
+	node := newTestNode(t)
 	if k, err := AddR(node, "test_data"); err != nil {
 		t.Fatal(err)
 	} else if k != "QmWCCga8AbTyfAQ7pTnGT6JgmRMAB3Qp8ZmTEFi5q5o8jC" {
@@ -38,19 +53,60 @@ func TestAddRecursive(t *testing.T) {
 	}
 }
 
-func TestAddGCLive(t *testing.T) {
-	r := &repo.Mock{
-		C: config.Config{
-			Identity: config.Identity{
-				PeerID: "Qmfoo", // required by offline node
-			},
-		},
-		D: testutil.ThreadSafeCloserMapDatastore(),
+func TestAddRecursiveWorkers(t *testing.T) {
+	node := newTestNode(t)
+
+	addDir := func(workers int) (string, []string) {
+		stat, err := os.Lstat("test_data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := files.NewSerialFile("test_data", "test_data", false, stat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		out := make(chan interface{}, 64)
+		adder, err := NewAdder(context.Background(), node, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer adder.Close()
+		adder.RecursiveWorkers = workers
+
+		if err := adder.AddFile(f); err != nil {
+			t.Fatal(err)
+		}
+		root, err := adder.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := root.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		close(out)
+		var names []string
+		for o := range out {
+			names = append(names, o.(*AddedObject).Name)
+		}
+		return k.B58String(), names
 	}
-	node, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
-	if err != nil {
-		t.Fatal(err)
+
+	seqRoot, seqNames := addDir(1)
+	root, names := addDir(3)
+	if root != "QmWCCga8AbTyfAQ7pTnGT6JgmRMAB3Qp8ZmTEFi5q5o8jC" || root != seqRoot {
+		t.Fatalf("expected the root of a sequential add, got %s", root)
+	}
+	if !reflect.DeepEqual(names, seqNames) {
+		t.Fatalf("expected files reported in walk order %v, got %v", seqNames, names)
 	}
+}
+
+func TestAddGCLive(t *testing.T) {
+	node := newTestNode(t)
 
 	errs := make(chan error)
 	out := make(chan interface{})
@@ -95,7 +151,7 @@ func TestAddGCLive(t *testing.T) {
 	gcstarted := make(chan struct{})
 	go func() {
 		defer close(gcstarted)
-		gcchan, err := gc.GC(context.Background(), node.Blockstore, node.Pinning)
+		gcchan, err := gc.GC(context.Background(), node.Blockstore, node.Pinning, nil)
 		if err != nil {
 			log.Error("GC ERROR:", err)
 			errs <- err
@@ -159,3 +215,1632 @@ func TestAddGCLive(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestAddVerify(t *testing.T) {
+	node := newTestNode(t)
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.Chunker = "size-16"
+
+	data := ioutil.NopCloser(bytes.NewBufferString("some data that spans a few blocks"))
+	err = adder.AddFile(files.NewReaderFile("a", "a", data, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := adder.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := adder.PinRoot(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := adder.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(root.Links) == 0 {
+		t.Fatal("expected root to have links")
+	}
+
+	if err := node.Blockstore.DeleteBlock(key.Key(root.Links[0].Hash)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := adder.Verify(); err == nil {
+		t.Fatal("expected verify to fail after removing a block")
+	}
+}
+
+func TestAddGCStress(t *testing.T) {
+	node := newTestNode(t)
+
+	const count = 20
+	roots := make(chan key.Key, count)
+	errs := make(chan error, 2*count)
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			adder, err := NewAdder(context.Background(), node, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			data := ioutil.NopCloser(bytes.NewBufferString(fmt.Sprintf("stress test data %d", i)))
+			name := fmt.Sprintf("file%d", i)
+			if err := adder.AddFile(files.NewReaderFile(name, name, data, nil)); err != nil {
+				adder.Close()
+				errs <- err
+				return
+			}
+
+			nd, err := adder.Finalize()
+			if err != nil {
+				adder.Close()
+				errs <- err
+				return
+			}
+
+			if err := adder.PinRoot(); err != nil {
+				errs <- err
+				return
+			}
+
+			k, err := nd.Key()
+			if err != nil {
+				errs <- err
+				return
+			}
+			roots <- k
+		}(i)
+
+		go func() {
+			defer wg.Done()
+			rmed, err := gc.GC(context.Background(), node.Blockstore, node.Pinning, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			for range rmed {
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(roots)
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	for k := range roots {
+		root, err := node.DAG.Get(ctx, k)
+		if err != nil {
+			t.Fatalf("added root %s is unreadable: %s", k, err)
+		}
+
+		err = dag.EnumerateChildren(ctx, node.DAG, root, key.NewKeySet())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAddDuplicateNames(t *testing.T) {
+	node := newTestNode(t)
+
+	addTwo := func(rename bool) (*dag.Node, error) {
+		adder, err := NewAdder(context.Background(), node, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer adder.Close()
+		adder.Wrap = true
+		adder.RenameDuplicates = rename
+
+		for _, data := range []string{"first", "second"} {
+			f := files.NewReaderFile("a.txt", "dir"+data+"/a.txt", ioutil.NopCloser(bytes.NewBufferString(data)), nil)
+			if err := adder.AddFile(f); err != nil {
+				return nil, err
+			}
+		}
+		return adder.Finalize()
+	}
+
+	if _, err := addTwo(false); err == nil {
+		t.Fatal("expected duplicate names to fail the add")
+	}
+
+	root, err := addTwo(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(root.Links) != 2 {
+		t.Fatalf("expected 2 links, got %d", len(root.Links))
+	}
+	for _, name := range []string{"a.txt", "a-1.txt"} {
+		if _, err := root.GetNodeLink(name); err != nil {
+			t.Fatalf("missing link %s: %s", name, err)
+		}
+	}
+}
+
+func TestAddManifest(t *testing.T) {
+	node := newTestNode(t)
+
+	k, err := Add(node, bytes.NewBufferString("manifest content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.Wrap = true
+
+	manifest := fmt.Sprintf("a/b/file\t%s\nother\t%s\n", k, k)
+	if err := adder.AddManifest(bytes.NewBufferString(manifest)); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := adder.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := adder.PinRoot(); err != nil {
+		t.Fatal(err)
+	}
+
+	nd, err := node.Resolver.ResolveLinks(context.Background(), root, []string{"a", "b", "file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fk, err := nd[len(nd)-1].Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fk.B58String() != k {
+		t.Fatalf("expected %s, got %s", k, fk)
+	}
+
+	missing, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer missing.Close()
+	err = missing.AddManifest(bytes.NewBufferString("x\tQmWLdkp93sNxGRjnFHPaYg8tCQ35NBY3XPn6KiETd3Z4WR\n"))
+	if err == nil {
+		t.Fatal("expected manifest referencing a missing object to fail")
+	}
+}
+
+func TestAddHiddenFilesOnly(t *testing.T) {
+	node := newTestNode(t)
+
+	rf := func(name, data string) files.File {
+		return files.NewReaderFile(name, name, ioutil.NopCloser(bytes.NewBufferString(data)), nil)
+	}
+	dir := files.NewSliceFile("dir", "dir", []files.File{
+		rf("dir/.env", "dotfile"),
+		rf("dir/visible", "visible"),
+		files.NewSliceFile("dir/.git", "dir/.git", []files.File{
+			rf("dir/.git/HEAD", "ref"),
+		}),
+	})
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Hidden = false
+	adder.HiddenFilesOnly = true
+
+	if err := adder.AddFile(dir); err != nil {
+		t.Fatal(err)
+	}
+	root, err := adder.RootNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{".env", "visible"} {
+		if _, err := root.GetNodeLink(name); err != nil {
+			t.Fatalf("missing link %s: %s", name, err)
+		}
+	}
+	if _, err := root.GetNodeLink(".git"); err == nil {
+		t.Fatal("hidden directory .git should have been skipped")
+	}
+}
+
+func TestAddProgressNoOutput(t *testing.T) {
+	node := newTestNode(t)
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Progress = true
+
+	f := files.NewReaderFile("dir/file", "dir/file", ioutil.NopCloser(bytes.NewBufferString("data")), nil)
+	dir := files.NewSliceFile("dir", "dir", []files.File{f})
+	if err := adder.AddFile(dir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddChecksum(t *testing.T) {
+	node := newTestNode(t)
+
+	out := make(chan interface{}, 8)
+	adder, err := NewAdder(context.Background(), node, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Checksum = DefaultChecksum
+
+	data := []byte("checksummed contents")
+	f := files.NewReaderFile("file", "file", ioutil.NopCloser(bytes.NewReader(data)), nil)
+	if err := adder.AddFile(f); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(data)
+	expected := hex.EncodeToString(sum[:])
+	obj := (<-out).(*AddedObject)
+	if obj.Checksum != expected {
+		t.Fatalf("expected checksum %s, got %q", expected, obj.Checksum)
+	}
+}
+
+func TestAddMaxLinks(t *testing.T) {
+	node := newTestNode(t)
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Chunker = "size-16"
+	adder.MaxLinks = 2
+
+	// 16 chunks, so that intermediate nodes are needed
+	nd, err := adder.add(bytes.NewReader(make([]byte, 256)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var check func(nd *dag.Node)
+	check = func(nd *dag.Node) {
+		if len(nd.Links) > 2 {
+			t.Fatalf("node has %d links, expected at most 2", len(nd.Links))
+		}
+		for _, l := range nd.Links {
+			child, err := l.GetNode(context.Background(), node.DAG)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(child)
+		}
+	}
+	check(nd)
+	if len(nd.Links) != 2 {
+		t.Fatalf("expected root with 2 links, got %d", len(nd.Links))
+	}
+}
+
+func TestAddEntryOrder(t *testing.T) {
+	node := newTestNode(t)
+
+	names := []string{"b", "a", "d", "c", "e"}
+	addInOrder := func(order []int) key.Key {
+		var entries []files.File
+		for _, i := range order {
+			name := "dir/" + names[i]
+			entries = append(entries, files.NewReaderFile(name, name, ioutil.NopCloser(bytes.NewBufferString(name)), nil))
+		}
+
+		adder, err := NewAdder(context.Background(), node, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer adder.Close()
+		if err := adder.AddFile(files.NewSliceFile("dir", "dir", entries)); err != nil {
+			t.Fatal(err)
+		}
+		root, err := adder.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := root.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	// the root must not depend on the order the filesystem lists entries in
+	want := addInOrder([]int{0, 1, 2, 3, 4})
+	for _, order := range [][]int{{4, 3, 2, 1, 0}, {2, 4, 0, 3, 1}, {1, 0, 3, 2, 4}} {
+		if k := addInOrder(order); k != want {
+			t.Fatalf("adding entries in order %v gave root %s, expected %s", order, k, want)
+		}
+	}
+}
+
+func TestAddUnixfsType(t *testing.T) {
+	node := newTestNode(t)
+
+	fsNode := func(nd *dag.Node) *ft.FSNode {
+		fsn, err := ft.FSNodeFromBytes(nd.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fsn
+	}
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Chunker = "size-16"
+	adder.Trickle = true
+
+	// the direct blocks of a trickle dag are raw unless told otherwise
+	adder.UnixfsType = "file"
+	nd, err := adder.add(bytes.NewReader(make([]byte, 64)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range nd.Links {
+		child, err := l.GetNode(context.Background(), node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ := fsNode(child).Type; typ != ft.TFile {
+			t.Fatalf("expected a file leaf, got %s", typ)
+		}
+	}
+
+	adder.UnixfsType = "raw"
+	nd, err = adder.add(bytes.NewReader(make([]byte, 8)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := fsNode(nd).Type; typ != ft.TRaw {
+		t.Fatalf("expected a raw single block file, got %s", typ)
+	}
+
+	adder.UnixfsType = "directory"
+	if _, err := adder.add(bytes.NewReader(make([]byte, 8))); err == nil {
+		t.Fatal("expected an error for an unsupported unixfs type")
+	}
+}
+
+func TestAddReportsShape(t *testing.T) {
+	node := newTestNode(t)
+
+	out := make(chan interface{}, 8)
+	adder, err := NewAdder(context.Background(), node, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Chunker = "size-16"
+	adder.MaxLinks = 2
+
+	// 5 chunks need three levels of links with 2 links per node
+	f := files.NewReaderFile("file", "file", ioutil.NopCloser(bytes.NewReader(make([]byte, 80))), nil)
+	if err := adder.AddFile(f); err != nil {
+		t.Fatal(err)
+	}
+
+	obj := (<-out).(*AddedObject)
+	if obj.Blocks != 5 {
+		t.Fatalf("expected 5 blocks, got %d", obj.Blocks)
+	}
+	if obj.Depth != 3 {
+		t.Fatalf("expected depth 3, got %d", obj.Depth)
+	}
+}
+
+func TestAddPinRootOnly(t *testing.T) {
+	node := newTestNode(t)
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.PinRootOnly = true
+
+	f := files.NewReaderFile("file", "file", ioutil.NopCloser(bytes.NewBufferString("staged")), nil)
+	if err := adder.AddFile(f); err != nil {
+		t.Fatal(err)
+	}
+	root, err := adder.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := adder.PinRoot(); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := root.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode, pinned, err := node.Pinning.IsPinned(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pinned || mode != "direct" {
+		t.Fatalf("expected root to be pinned directly, got pinned=%v mode=%q", pinned, mode)
+	}
+	if len(node.Pinning.RecursiveKeys()) != 0 {
+		t.Fatal("expected no recursive pins")
+	}
+}
+
+func TestAddUninitializedNode(t *testing.T) {
+	if _, err := NewAdder(context.Background(), &core.IpfsNode{}, nil); err == nil {
+		t.Fatal("expected an error adding through a node without a blockstore")
+	}
+	if _, err := NewLocalAdder(context.Background(), nil, nil); err == nil {
+		t.Fatal("expected an error adding through a nil node")
+	}
+}
+
+func TestAddMetadata(t *testing.T) {
+	node := newTestNode(t)
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	meta := []byte(`{"title":"test"}`)
+	adder.Metadata = meta
+
+	f := files.NewReaderFile("file", "file", ioutil.NopCloser(bytes.NewReader([]byte("content"))), nil)
+	if err := adder.AddFile(f); err != nil {
+		t.Fatal(err)
+	}
+	root, err := adder.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := adder.PinRoot(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(root.Links) != 2 || root.Links[0].Name != MetadataDataLink || root.Links[1].Name != MetadataMetaLink {
+		t.Fatalf("expected root with 'data' and 'meta' links, got %v", root.Links)
+	}
+
+	metanode, err := root.Links[1].GetNode(context.Background(), node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := uio.NewDagReader(context.Background(), metanode, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, meta) {
+		t.Fatalf("expected metadata %q, got %q", meta, got)
+	}
+
+	k, err := root.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, pinned, err := node.Pinning.IsPinned(k); err != nil || !pinned {
+		t.Fatalf("expected the wrapping root to be pinned (err: %v)", err)
+	}
+}
+
+func TestAddCover(t *testing.T) {
+	node := newTestNode(t)
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	cover := []byte("thumbnail")
+	adder.Cover = cover
+	adder.CoverName = "thumb.jpg"
+
+	f := files.NewReaderFile("video", "video", ioutil.NopCloser(bytes.NewReader([]byte("content"))), nil)
+	if err := adder.AddFile(f); err != nil {
+		t.Fatal(err)
+	}
+	root, err := adder.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := adder.PinRoot(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(root.Links) != 2 {
+		t.Fatalf("expected root with 2 links, got %v", root.Links)
+	}
+	if _, err := root.GetNodeLink(MetadataDataLink); err != nil {
+		t.Fatal(err)
+	}
+	covernode, err := root.GetLinkedNode(context.Background(), node.DAG, "thumb.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := uio.NewDagReader(context.Background(), covernode, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, cover) {
+		t.Fatalf("expected cover %q, got %q", cover, got)
+	}
+
+	k, err := root.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, pinned, err := node.Pinning.IsPinned(k); err != nil || !pinned {
+		t.Fatalf("expected the wrapping root to be pinned (err: %v)", err)
+	}
+}
+
+func TestAddMaxDirEntries(t *testing.T) {
+	node := newTestNode(t)
+
+	mkdir := func() files.File {
+		var entries []files.File
+		for i := 0; i < 3; i++ {
+			name := fmt.Sprintf("dir/file%d", i)
+			entries = append(entries, files.NewReaderFile(name, name, ioutil.NopCloser(bytes.NewBufferString(name)), nil))
+		}
+		return files.NewSliceFile("dir", "dir", entries)
+	}
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.MaxDirEntries = 2
+	if err := adder.AddFile(mkdir()); err == nil {
+		t.Fatal("expected an error adding a directory over the entry limit")
+	}
+
+	adder, err = NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.MaxDirEntries = 3
+	if err := adder.AddFile(mkdir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddMaxDepth(t *testing.T) {
+	node := newTestNode(t)
+
+	// dir/a/b/file
+	mkdir := func() files.File {
+		file := files.NewReaderFile("dir/a/b/file", "dir/a/b/file", ioutil.NopCloser(bytes.NewBufferString("deep")), nil)
+		b := files.NewSliceFile("dir/a/b", "dir/a/b", []files.File{file})
+		a := files.NewSliceFile("dir/a", "dir/a", []files.File{b})
+		return files.NewSliceFile("dir", "dir", []files.File{a})
+	}
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.MaxDepth = 2
+	err = adder.AddFile(mkdir())
+	derr, ok := err.(*DepthLimitError)
+	if !ok {
+		t.Fatalf("expected a DepthLimitError, got %v", err)
+	}
+	if derr.Path != "dir/a/b" {
+		t.Fatalf("expected the error for dir/a/b, got %s", derr.Path)
+	}
+
+	adder, err = NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.MaxDepth = 3
+	if err := adder.AddFile(mkdir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddCheck(t *testing.T) {
+	node := newTestNode(t)
+
+	stored := make([]byte, 1024)
+	if _, err := Add(node, bytes.NewReader(stored)); err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan interface{}, 2)
+	adder, err := NewCheckAdder(context.Background(), node, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+
+	inputs := []struct {
+		name    string
+		data    []byte
+		present bool
+	}{
+		{"stored", stored, true},
+		{"new", []byte("not stored"), false},
+	}
+
+	for _, in := range inputs {
+		f := files.NewReaderFile(in.name, in.name, ioutil.NopCloser(bytes.NewReader(in.data)), nil)
+		if err := adder.AddFile(f); err != nil {
+			t.Fatal(err)
+		}
+		obj := (<-out).(*AddedObject)
+		if obj.Present != in.present {
+			t.Fatalf("%s: expected Present %t, got %t", in.name, in.present, obj.Present)
+		}
+
+		has, err := node.Blockstore.Has(key.B58KeyDecode(obj.Hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if has != in.present {
+			t.Fatalf("%s: expected the blockstore to have the file: %t", in.name, in.present)
+		}
+	}
+}
+
+func TestAddMaxTotal(t *testing.T) {
+	node := newTestNode(t)
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.MaxTotal = 10
+
+	// each file is under the limit, but not both together
+	for i, name := range []string{"first", "second"} {
+		f := files.NewReaderFile(name, name, ioutil.NopCloser(bytes.NewReader(make([]byte, 6))), nil)
+		err := adder.AddFile(f)
+		if i == 0 && err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 && err == nil {
+			t.Fatal("expected an error once the total limit was exceeded")
+		}
+	}
+}
+
+func TestAddFailedAfterGCPause(t *testing.T) {
+	node := newTestNode(t)
+	before := len(node.Pinning.RecursiveKeys())
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.MaxTotal = 10
+
+	f := files.NewReaderFile("first", "first", ioutil.NopCloser(bytes.NewReader(make([]byte, 6))), nil)
+	if err := adder.AddFile(f); err != nil {
+		t.Fatal(err)
+	}
+
+	// what maybePauseForGC does when GC asks for the pin lock
+	if err := adder.pinTempRoot(); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.Pinning.RecursiveKeys()) != before+1 {
+		t.Fatal("expected the partial root to be pinned during the pause")
+	}
+
+	f = files.NewReaderFile("second", "second", ioutil.NopCloser(bytes.NewReader(make([]byte, 6))), nil)
+	if err := adder.AddFile(f); err == nil {
+		t.Fatal("expected an error once the total limit was exceeded")
+	}
+	if err := adder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(node.Pinning.RecursiveKeys()) != before {
+		t.Fatal("a failed add left the partial root pinned")
+	}
+}
+
+func TestAddReaderBuffer(t *testing.T) {
+	node := newTestNode(t)
+
+	data := make([]byte, 300000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	addWithBuffer := func(size int) string {
+		out := make(chan interface{}, 1)
+		adder, err := NewAdder(context.Background(), node, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer adder.Close()
+		adder.ReaderBuffer = size
+
+		f := files.NewReaderFile("file", "file", ioutil.NopCloser(bytes.NewReader(data)), nil)
+		if err := adder.AddFile(f); err != nil {
+			t.Fatal(err)
+		}
+		return (<-out).(*AddedObject).Hash
+	}
+
+	// buffering must not change what is added
+	if unbuffered, buffered := addWithBuffer(0), addWithBuffer(4096); unbuffered != buffered {
+		t.Fatalf("expected the same hash with a read buffer, got %s and %s", unbuffered, buffered)
+	}
+}
+
+func TestAddWrapPinsRootOnly(t *testing.T) {
+	node := newTestNode(t)
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Wrap = true
+
+	for _, name := range []string{"a", "b", "c"} {
+		f := files.NewReaderFile(name, name, ioutil.NopCloser(bytes.NewBufferString(name)), nil)
+		if err := adder.AddFile(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := adder.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := adder.PinRoot(); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := root.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recursive := node.Pinning.RecursiveKeys()
+	if len(recursive) != 1 || recursive[0] != k {
+		t.Fatalf("expected only the wrapper %s to be pinned recursively, got %v", k, recursive)
+	}
+	if direct := node.Pinning.DirectKeys(); len(direct) != 0 {
+		t.Fatalf("expected no direct pins, got %v", direct)
+	}
+}
+
+func TestAddPinRules(t *testing.T) {
+	node := newTestNode(t)
+
+	rules, err := ParsePinRules(bytes.NewBufferString("# comment\n\n*.iso\tnone\n*.txt\trecursive\n**\tdirect\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"*.txt\n", "*.txt\tindirect\n", "[\trecursive\n"} {
+		if _, err := ParsePinRules(bytes.NewBufferString(bad)); err == nil {
+			t.Fatalf("expected an error parsing %q", bad)
+		}
+	}
+
+	out := make(chan interface{}, 8)
+	adder, err := NewAdder(context.Background(), node, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Pin = false
+	adder.PinRules = rules
+
+	inputs := []struct {
+		name string
+		mode string
+	}{
+		{"image.iso", PinRuleNone},
+		{"notes.txt", PinRuleRecursive},
+		{"other", PinRuleDirect},
+	}
+
+	keys := make(map[string]key.Key)
+	for _, in := range inputs {
+		f := files.NewReaderFile(in.name, in.name, ioutil.NopCloser(bytes.NewBufferString(in.name)), nil)
+		if err := adder.AddFile(f); err != nil {
+			t.Fatal(err)
+		}
+		obj := (<-out).(*AddedObject)
+		if obj.PinMode != in.mode {
+			t.Fatalf("%s: expected pin mode %s, got %s", in.name, in.mode, obj.PinMode)
+		}
+		keys[in.name] = key.B58KeyDecode(obj.Hash)
+	}
+	if _, err := adder.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if err := adder.PinRoot(); err != nil {
+		t.Fatal(err)
+	}
+
+	if recursive := node.Pinning.RecursiveKeys(); len(recursive) != 1 || recursive[0] != keys["notes.txt"] {
+		t.Fatalf("expected only notes.txt to be pinned recursively, got %v", recursive)
+	}
+	if direct := node.Pinning.DirectKeys(); len(direct) != 1 || direct[0] != keys["other"] {
+		t.Fatalf("expected only other to be pinned directly, got %v", direct)
+	}
+}
+
+func TestAddAppendTo(t *testing.T) {
+	node := newTestNode(t)
+
+	head := bytes.Repeat([]byte("log line\n"), 100)
+	tail := bytes.Repeat([]byte("more lines\n"), 100)
+
+	mkadder := func(trickle bool, base *dag.Node) *Adder {
+		adder, err := NewAdder(context.Background(), node, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.Chunker = "size-64"
+		adder.MaxLinks = 3
+		adder.Trickle = trickle
+		adder.AppendTo = base
+		return adder
+	}
+
+	base, err := mkadder(true, nil).add(bytes.NewReader(head))
+	if err != nil {
+		t.Fatal(err)
+	}
+	appended, err := mkadder(true, base).add(bytes.NewReader(tail))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dr, err := uio.NewDagReader(context.Background(), appended, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, append(head, tail...)) {
+		t.Fatal("appended file does not hold both parts")
+	}
+
+	// the first, full child tree of the base is shared
+	if len(base.Links) == 0 || len(appended.Links) <= len(base.Links) {
+		t.Fatalf("expected the appended file to extend the base, got %d and %d links", len(base.Links), len(appended.Links))
+	}
+	if !bytes.Equal(base.Links[0].Hash, appended.Links[0].Hash) {
+		t.Fatal("expected the first block of the base to be shared")
+	}
+
+	balanced, err := mkadder(false, nil).add(bytes.NewReader(head))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mkadder(true, balanced).add(bytes.NewReader(tail)); err == nil {
+		t.Fatal("expected an error appending to a balanced dag")
+	}
+}
+
+func TestAddSplit(t *testing.T) {
+	node := newTestNode(t)
+
+	data := make([]byte, 2500)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Split = 1000
+	if err := adder.AddFile(files.NewReaderFile("big", "big", ioutil.NopCloser(bytes.NewReader(data)), nil)); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := adder.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readLink := func(name string) []byte {
+		nd, err := dir.GetLinkedNode(context.Background(), node.DAG, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dr, err := uio.NewDagReader(context.Background(), nd, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadAll(dr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	var manifest SplitManifest
+	if err := json.Unmarshal(readLink(SplitManifestLink), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Size != int64(len(data)) || len(manifest.Parts) != 3 {
+		t.Fatalf("expected 3 parts of %d bytes, got %v", len(data), manifest)
+	}
+
+	var joined []byte
+	for i, name := range manifest.Parts {
+		part := readLink(name)
+		if i < 2 && len(part) != 1000 {
+			t.Fatalf("expected %s to hold 1000 bytes, got %d", name, len(part))
+		}
+		joined = append(joined, part...)
+	}
+	if !bytes.Equal(joined, data) {
+		t.Fatal("parts do not add up to the file")
+	}
+}
+
+func TestAddStatsBlocks(t *testing.T) {
+	node := newTestNode(t)
+
+	stored := make([]byte, 4096)
+	for i := range stored {
+		stored[i] = byte(i % 251)
+	}
+	fresh := bytes.Repeat([]byte("x"), 5000)
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.Chunker = "size-1024"
+	if _, err := adder.add(bytes.NewReader(stored)); err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan interface{}, 16)
+	adder, err = NewAdder(context.Background(), node, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Chunker = "size-1024"
+	adder.Stats = true
+	for _, in := range []struct {
+		name string
+		data []byte
+	}{{"stored", stored}, {"fresh", fresh}} {
+		if err := adder.AddFile(files.NewReaderFile(in.name, in.name, ioutil.NopCloser(bytes.NewReader(in.data)), nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := adder.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	var summary *AddSummary
+	for o := range out {
+		summary = o.(*AddedObject).Summary
+	}
+	if summary == nil {
+		t.Fatal("expected the summary last")
+	}
+
+	// at least the 4 leaves and the root of stored, and the 3 leaves of
+	// fresh that repeat its first one
+	if summary.Existing < 8 {
+		t.Fatalf("expected at least 8 blocks already stored, got %d of %d", summary.Existing, summary.Blocks)
+	}
+	if summary.Blocks <= summary.Existing {
+		t.Fatalf("expected new blocks too, got %d of %d already stored", summary.Existing, summary.Blocks)
+	}
+	if summary.BytesSaved < 4096+3*1024 {
+		t.Fatalf("expected at least %d bytes saved, got %d", 4096+3*1024, summary.BytesSaved)
+	}
+}
+
+func TestAddNameIndex(t *testing.T) {
+	node := newTestNode(t)
+
+	dir, err := ioutil.TempDir("", "name-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	indexPath := dir + "/index"
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Silent = true
+	adder.NameIndex = NewNameIndex(indexPath)
+
+	var expected bytes.Buffer
+	var entries []files.File
+	for _, name := range []string{"b", "a"} {
+		data := "contents of " + name
+		nd, err := adder.add(bytes.NewBufferString(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := nd.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&expected, "%s\tdir/%s\n", k.B58String(), name)
+		entries = append(entries, files.NewReaderFile("dir/"+name, "dir/"+name, ioutil.NopCloser(bytes.NewBufferString(data)), nil))
+	}
+	if err := adder.AddFile(files.NewSliceFile("dir", "dir", entries)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(indexPath); !os.IsNotExist(err) {
+		t.Fatalf("expected no index before it is committed, got %v", err)
+	}
+	if err := adder.NameIndex.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the directory itself is not indexed
+	index, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(index) != expected.String() {
+		t.Fatalf("expected index %q, got %q", expected.String(), index)
+	}
+
+	names, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 {
+		t.Fatalf("expected only the index in %s, got %d files", dir, len(names))
+	}
+}
+
+func TestAddOffsetIndex(t *testing.T) {
+	node := newTestNode(t)
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Chunker = "size-100"
+	adder.OffsetIndex = true
+
+	data := make([]byte, 1050)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	f := files.NewReaderFile("file", "file", ioutil.NopCloser(bytes.NewReader(data)), nil)
+	if err := adder.AddFile(f); err != nil {
+		t.Fatal(err)
+	}
+	root, err := adder.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(root.Links) != 2 || root.Links[0].Name != MetadataDataLink || root.Links[1].Name != OffsetIndexLink {
+		t.Fatalf("expected root with 'data' and 'offsets' links, got %v", root.Links)
+	}
+	indexnode, err := root.Links[1].GetNode(context.Background(), node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr, err := uio.NewDagReader(context.Background(), indexnode, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := bytes.Split(bytes.TrimSuffix(index, []byte("\n")), []byte("\n"))
+	if len(lines) != 11 {
+		t.Fatalf("expected 11 entries, got %d:\n%s", len(lines), index)
+	}
+	var next uint64
+	for _, line := range lines {
+		var offset, size uint64
+		var hash string
+		if _, err := fmt.Sscanf(string(line), "%d %d %s", &offset, &size, &hash); err != nil {
+			t.Fatalf("bad entry %q: %s", line, err)
+		}
+		if offset != next {
+			t.Fatalf("expected an entry at offset %d, got %q", next, line)
+		}
+		next += size
+
+		nd, err := node.DAG.Get(context.Background(), key.B58KeyDecode(hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pb, err := ft.FromBytes(nd.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(pb.Data, data[offset:offset+size]) {
+			t.Fatalf("entry %q does not hold the file's bytes at its offset", line)
+		}
+	}
+	if next != uint64(len(data)) {
+		t.Fatalf("expected the entries to cover %d bytes, got %d", len(data), next)
+	}
+}
+
+func TestAddOnProgress(t *testing.T) {
+	node := newTestNode(t)
+
+	// no output channel, the callback alone reports progress
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	var calls int
+	var lastName string
+	var lastBytes int64
+	adder.OnProgress = func(name string, bytes int64) {
+		calls++
+		lastName, lastBytes = name, bytes
+	}
+
+	data := make([]byte, 3*progressReaderIncrement)
+	file := files.NewReaderFile("file", "file", ioutil.NopCloser(bytes.NewReader(data)), nil)
+	if err := adder.AddFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if calls < 3 {
+		t.Fatalf("expected at least 3 progress calls, got %d", calls)
+	}
+	if lastName != "file" || lastBytes != int64(len(data)) {
+		t.Fatalf("expected last progress of file at %d bytes, got %s at %d", len(data), lastName, lastBytes)
+	}
+}
+
+func TestAddRejectType(t *testing.T) {
+	node := newTestNode(t)
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Silent = true
+	adder.RejectTypes = []string{"image/*"}
+
+	png := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), make([]byte, 100)...)
+	err = adder.AddFile(files.NewReaderFile("img", "img", ioutil.NopCloser(bytes.NewReader(png)), nil))
+	rerr, ok := err.(*RejectedTypeError)
+	if !ok {
+		t.Fatalf("expected a RejectedTypeError, got %v", err)
+	}
+	if rerr.Path != "img" || rerr.Type != "image/png" {
+		t.Fatalf("expected img to be rejected as image/png, got %s as %s", rerr.Path, rerr.Type)
+	}
+
+	// longer than what is sniffed, all of it must still be added
+	text := bytes.Repeat([]byte("some text\n"), 100)
+	expected, err := adder.add(bytes.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := adder.AddFile(files.NewReaderFile("text", "text", ioutil.NopCloser(bytes.NewReader(text)), nil)); err != nil {
+		t.Fatal(err)
+	}
+	root, err := adder.RootNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := root.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := expected.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != k {
+		t.Fatalf("expected the text to be added as %s, got %s", k, got)
+	}
+}
+
+func TestAddNormalizeNames(t *testing.T) {
+	node := newTestNode(t)
+
+	addTree := func(sep string, normalize bool) key.Key {
+		sub := "dir" + sep + "sub"
+		file := sub + sep + "a"
+		tree := files.NewSliceFile("dir", "dir", []files.File{
+			files.NewSliceFile(sub, sub, []files.File{
+				files.NewReaderFile(file, file, ioutil.NopCloser(bytes.NewBufferString("data")), nil),
+			}),
+		})
+
+		adder, err := NewAdder(context.Background(), node, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer adder.Close()
+		adder.NormalizeNames = normalize
+		if err := adder.AddFile(tree); err != nil {
+			t.Fatal(err)
+		}
+		root, err := adder.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := root.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	want := addTree("/", false)
+	if k := addTree(`\`, true); k != want {
+		t.Fatalf("expected the tree added with backslashes to give root %s, got %s", want, k)
+	}
+	if k := addTree(`\`, false); k == want {
+		t.Fatal("expected backslashes to be kept in names by default")
+	}
+}
+
+func TestAddStreamManifest(t *testing.T) {
+	node := newTestNode(t)
+
+	dir, err := ioutil.TempDir("", "stream-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	manifestPath := dir + "/manifest"
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Silent = true
+	adder.Chunker = "size-100"
+	adder.StreamManifest = NewStreamManifest(manifestPath)
+
+	data := make([]byte, 1050)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	entries := []files.File{
+		files.NewReaderFile("dir/data", "dir/data", ioutil.NopCloser(bytes.NewReader(data)), nil),
+		files.NewReaderFile("dir/empty", "dir/empty", ioutil.NopCloser(bytes.NewReader(nil)), nil),
+	}
+	if err := adder.AddFile(files.NewSliceFile("dir", "dir", entries)); err != nil {
+		t.Fatal(err)
+	}
+	if err := adder.StreamManifest.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSuffix(manifest, []byte("\n")), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected a line for each file, got %q", manifest)
+	}
+
+	// the listed blocks give the file back, in order
+	fields := bytes.Split(lines[0], []byte("\t"))
+	if len(fields) != 3 || string(fields[1]) != "dir/data" {
+		t.Fatalf("unexpected line %q", lines[0])
+	}
+	var got []byte
+	for _, entry := range bytes.Split(fields[2], []byte(" ")) {
+		var hash string
+		var size int
+		if _, err := fmt.Sscanf(string(bytes.Replace(entry, []byte(":"), []byte(" "), 1)), "%s %d", &hash, &size); err != nil {
+			t.Fatalf("unexpected entry %q: %s", entry, err)
+		}
+		nd, err := node.DAG.Get(context.Background(), key.B58KeyDecode(hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pb, err := ft.FromBytes(nd.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pb.Data) != size {
+			t.Fatalf("block %s: listed with %d bytes, holds %d", hash, size, len(pb.Data))
+		}
+		got = append(got, pb.Data...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("the listed blocks don't give the file back")
+	}
+
+	fields = bytes.Split(lines[1], []byte("\t"))
+	if len(fields) != 3 || string(fields[1]) != "dir/empty" || len(fields[2]) != 0 {
+		t.Fatalf("expected an empty list for the empty file, got %q", lines[1])
+	}
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func TestAddOnTruncate(t *testing.T) {
+	node := newTestNode(t)
+
+	// the stat of a 1000 byte file, of which only part can be read
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	tmp, err := ioutil.TempFile("", "on-truncate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := tmp.Stat()
+	tmp.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readers := map[string]func() io.Reader{
+		"shrunk": func() io.Reader {
+			return bytes.NewReader(data[:500])
+		},
+		"vanished": func() io.Reader {
+			return io.MultiReader(bytes.NewReader(data[:300]), failingReader{errors.New("stale file handle")})
+		},
+	}
+	read := map[string]int{"shrunk": 500, "vanished": 300}
+
+	for name, reader := range readers {
+		for _, mode := range []string{TruncateError, TruncateSkip, TruncatePartial} {
+			adder, err := NewAdder(context.Background(), node, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			adder.Silent = true
+			adder.OnTruncate = mode
+			entries := []files.File{
+				files.NewReaderFile("dir/"+name, "dir/"+name, ioutil.NopCloser(reader()), stat),
+				files.NewReaderFile("dir/ok", "dir/ok", ioutil.NopCloser(bytes.NewBufferString("ok")), nil),
+			}
+			err = adder.AddFile(files.NewSliceFile("dir", "dir", entries))
+
+			if mode == TruncateError {
+				terr, ok := err.(*TruncatedError)
+				if !ok {
+					t.Fatalf("%s, %s: expected a TruncatedError, got %v", name, mode, err)
+				}
+				if terr.Read != int64(read[name]) {
+					t.Fatalf("%s, %s: expected %d bytes read, got %d", name, mode, read[name], terr.Read)
+				}
+				adder.Close()
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s, %s: %s", name, mode, err)
+			}
+
+			root, err := adder.RootNode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			links := make(map[string]key.Key)
+			for _, l := range root.Links {
+				links[l.Name] = key.Key(l.Hash)
+			}
+			if _, ok := links["ok"]; !ok {
+				t.Fatalf("%s, %s: the other file was not added", name, mode)
+			}
+			k, added := links[name]
+			switch mode {
+			case TruncateSkip:
+				if added {
+					t.Fatalf("%s, %s: expected the file to be skipped", name, mode)
+				}
+			case TruncatePartial:
+				nd, err := adder.add(bytes.NewReader(data[:read[name]]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected, err := nd.Key()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if k != expected {
+					t.Fatalf("%s, %s: expected the %d bytes read to be added as %s, got %s", name, mode, read[name], expected, k)
+				}
+			}
+			adder.Close()
+		}
+	}
+}
+
+func TestAddPreChunked(t *testing.T) {
+	node := newTestNode(t)
+
+	data := make([]byte, 1050)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	chunkDir := func(names []string, sizes []int) files.File {
+		var entries []files.File
+		var off int
+		for i, name := range names {
+			chunk := data[off : off+sizes[i]]
+			off += sizes[i]
+			entries = append(entries, files.NewReaderFile("chunks/"+name, "chunks/"+name, ioutil.NopCloser(bytes.NewReader(chunk)), nil))
+		}
+		return files.NewSliceFile("chunks", "chunks", entries)
+	}
+
+	var names []string
+	var sizes []int
+	for i := 0; i*100 < len(data); i++ {
+		names = append(names, fmt.Sprintf("%04d", i))
+		size := len(data) - i*100
+		if size > 100 {
+			size = 100
+		}
+		sizes = append(sizes, size)
+	}
+
+	for _, trickle := range []bool{false, true} {
+		adder, err := NewAdder(context.Background(), node, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.Trickle = trickle
+		adder.Chunker = "size-100"
+		nd, err := adder.add(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := nd.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		adder.Chunker = ""
+		if err := adder.AddPreChunked(chunkDir(names, sizes)); err != nil {
+			t.Fatal(err)
+		}
+		root, err := adder.RootNode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := root.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k != expected {
+			t.Fatalf("trickle=%t: expected the chunks to be added as %s, got %s", trickle, expected, k)
+		}
+		adder.Close()
+	}
+
+	// chunks out of name order, and empty ones, are refused
+	bad := []struct {
+		names []string
+		sizes []int
+	}{
+		{[]string{"b", "a"}, []int{10, 10}},
+		{[]string{"a", "b"}, []int{10, 0}},
+	}
+	for _, c := range bad {
+		adder, err := NewAdder(context.Background(), node, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := adder.AddPreChunked(chunkDir(c.names, c.sizes)); err == nil {
+			t.Fatalf("expected chunks %v of sizes %v to be refused", c.names, c.sizes)
+		}
+		adder.Close()
+	}
+}
+
+func TestAddJournalStdin(t *testing.T) {
+	node := newTestNode(t)
+
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journal, err := OpenJournal(dir + "/journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	// two runs adding different streams from stdin, which are unnamed
+	var hashes []string
+	for _, data := range []string{"first stream", "second stream"} {
+		out := make(chan interface{}, 8)
+		adder, err := NewAdder(context.Background(), node, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.Journal = journal
+		file := files.NewReaderFile("", "", ioutil.NopCloser(bytes.NewBufferString(data)), nil)
+		if err := adder.AddFile(file); err != nil {
+			t.Fatal(err)
+		}
+		adder.Close()
+		hashes = append(hashes, (<-out).(*AddedObject).Hash)
+	}
+
+	if hashes[0] == hashes[1] {
+		t.Fatalf("the second stream was added as the first one, %s", hashes[0])
+	}
+	if len(journal.done) != 0 {
+		t.Fatalf("expected no journal entries for stdin, got %v", journal.done)
+	}
+}
@@ -0,0 +1,60 @@
+package coreunix
+
+import (
+	"errors"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+// errBlockLimit stops the walk of ListBlocks once it reached its max.
+var errBlockLimit = errors.New("block limit reached")
+
+// ListBlocks calls fn with the key of every block of the DAG rooted at root,
+// once each, parents before their children in link order, so the root comes
+// first. With max positive, it stops after that many blocks.
+func ListBlocks(ctx context.Context, ds dag.DAGService, root *dag.Node, max int, fn func(key.Key) error) error {
+	l := &blockLister{ctx: ctx, ds: ds, max: max, fn: fn, seen: make(map[key.Key]struct{})}
+	err := l.list(root)
+	if err == errBlockLimit {
+		return nil
+	}
+	return err
+}
+
+type blockLister struct {
+	ctx  context.Context
+	ds   dag.DAGService
+	max  int
+	fn   func(key.Key) error
+	seen map[key.Key]struct{}
+}
+
+func (l *blockLister) list(nd *dag.Node) error {
+	k, err := nd.Key()
+	if err != nil {
+		return err
+	}
+	if l.max > 0 && len(l.seen) >= l.max {
+		return errBlockLimit
+	}
+	l.seen[k] = struct{}{}
+	if err := l.fn(k); err != nil {
+		return err
+	}
+
+	for _, lnk := range nd.Links {
+		if _, ok := l.seen[key.Key(lnk.Hash)]; ok {
+			continue
+		}
+		child, err := lnk.GetNode(l.ctx, l.ds)
+		if err != nil {
+			return err
+		}
+		if err := l.list(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
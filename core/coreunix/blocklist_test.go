@@ -0,0 +1,84 @@
+package coreunix
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/blocks/key"
+	"gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+func TestListBlocks(t *testing.T) {
+	node := newTestNode(t)
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Chunker = "size-16"
+	adder.MaxLinks = 3
+	// zeros, so that most leaves are the same block
+	root, err := adder.add(bytes.NewReader(make([]byte, 1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rk, err := root.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var listed []key.Key
+	err = ListBlocks(context.Background(), node.DAG, root, 0, func(k key.Key) error {
+		listed = append(listed, k)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listed[0] != rk {
+		t.Fatalf("expected the root %s first, got %s", rk, listed[0])
+	}
+	seen := make(map[key.Key]bool)
+	for _, k := range listed {
+		if seen[k] {
+			t.Fatalf("block %s listed twice", k)
+		}
+		seen[k] = true
+		nd, err := node.DAG.Get(context.Background(), k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range nd.Links {
+			if !contains(listed, key.Key(l.Hash)) {
+				t.Fatalf("link %s of %s was not listed", key.Key(l.Hash), k)
+			}
+		}
+	}
+
+	var capped []key.Key
+	err = ListBlocks(context.Background(), node.DAG, root, 3, func(k key.Key) error {
+		capped = append(capped, k)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(capped) != 3 {
+		t.Fatalf("expected 3 blocks, got %d", len(capped))
+	}
+	for i, k := range capped {
+		if k != listed[i] {
+			t.Fatalf("block %d: expected %s, got %s", i, listed[i], k)
+		}
+	}
+}
+
+func contains(keys []key.Key, k key.Key) bool {
+	for _, o := range keys {
+		if o == k {
+			return true
+		}
+	}
+	return false
+}
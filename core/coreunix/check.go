@@ -0,0 +1,55 @@
+package coreunix
+
+import (
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	core "github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/exchange/offline"
+	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+// NewCheckAdder returns an Adder that stores nothing. Files are chunked and
+// hashed as usual, and each one is reported with Present set if all of its
+// blocks are already in n's blockstore. As the blocks are not kept, the
+// adder must not be finalized or pinned.
+func NewCheckAdder(ctx context.Context, n *core.IpfsNode, out chan interface{}) (*Adder, error) {
+	if err := checkNode(n); err != nil {
+		return nil, err
+	}
+
+	presence := &presenceBlockstore{Blockstore: n.Blockstore}
+	adder, err := newAdder(ctx, n, presence, offline.Exchange(presence), out)
+	if err != nil {
+		return nil, err
+	}
+	adder.Pin = false
+	adder.presence = presence
+	return adder, nil
+}
+
+// presenceBlockstore reads from the blockstore it wraps, but drops all
+// writes, only noting whether a block written was missing from it.
+type presenceBlockstore struct {
+	bstore.Blockstore
+	missing bool // since the last reset
+}
+
+func (p *presenceBlockstore) Put(b *blocks.Block) error {
+	has, err := p.Has(b.Key())
+	if err != nil {
+		return err
+	}
+	if !has {
+		p.missing = true
+	}
+	return nil
+}
+
+func (p *presenceBlockstore) PutMany(bs []*blocks.Block) error {
+	for _, b := range bs {
+		if err := p.Put(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package coreunix
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+)
+
+// DefaultChecksum is the checksum algorithm used when none is given.
+const DefaultChecksum = "sha256"
+
+var checksums = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// NewChecksum returns a hash for the named checksum algorithm, one of sha1,
+// sha256 and sha512.
+func NewChecksum(alg string) (hash.Hash, error) {
+	newHash, ok := checksums[alg]
+	if !ok {
+		return nil, fmt.Errorf("unknown checksum algorithm %q", alg)
+	}
+	return newHash(), nil
+}
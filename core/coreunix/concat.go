@@ -0,0 +1,55 @@
+package coreunix
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-ipfs/commands/files"
+)
+
+// ConcatFile returns a regular file named name, holding the contents of the
+// files in dir one after another, in the order dir lists them. Added, it is a
+// single unixfs file, with a root that differs from those of the files added
+// separately. The files are only read as the returned file is, so dir can be
+// a stream such as a multipart request. dir must not contain directories.
+func ConcatFile(name string, dir files.File) files.File {
+	return files.NewReaderFile(name, name, &concatReader{dir: dir}, nil)
+}
+
+type concatReader struct {
+	dir files.File
+	cur files.File
+}
+
+func (c *concatReader) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			f, err := c.dir.NextFile()
+			if err != nil {
+				return 0, err
+			}
+			if f.IsDirectory() {
+				return 0, fmt.Errorf("cannot concatenate %s, it is a directory", f.FileName())
+			}
+			c.cur = f
+		}
+
+		n, err := c.cur.Read(p)
+		if err == io.EOF {
+			c.cur.Close()
+			c.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *concatReader) Close() error {
+	if c.cur != nil {
+		return c.cur.Close()
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package coreunix
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/commands/files"
+)
+
+func TestConcatFile(t *testing.T) {
+	var parts []files.File
+	for _, s := range []string{"first ", "", "second ", "third"} {
+		parts = append(parts, files.NewReaderFile("part", "part", ioutil.NopCloser(bytes.NewBufferString(s)), nil))
+	}
+
+	f := ConcatFile("", files.NewSliceFile("", "", parts))
+	if f.IsDirectory() {
+		t.Fatal("expected a regular file")
+	}
+	out, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "first second third"; string(out) != expected {
+		t.Fatalf("expected %q, got %q", expected, out)
+	}
+
+	dir := files.NewSliceFile("dir", "dir", nil)
+	f = ConcatFile("", files.NewSliceFile("", "", []files.File{dir}))
+	if _, err := ioutil.ReadAll(f); err == nil {
+		t.Fatal("expected an error concatenating a directory")
+	}
+}
@@ -0,0 +1,57 @@
+package coreunix
+
+import (
+	"encoding/binary"
+	"io"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+// ExportDAG writes every block of the DAG rooted at k to w, each one as a
+// section like those of a CAR file: the uvarint length of the rest of the
+// section, the block's multihash, and its data. The blocks are written once
+// each, children before their parents in link order, so the root comes last
+// and the same DAG always gives the same bytes.
+func ExportDAG(ctx context.Context, ds dag.DAGService, k key.Key, w io.Writer) error {
+	nd, err := ds.Get(ctx, k)
+	if err != nil {
+		return err
+	}
+	return exportNode(ctx, ds, nd, w, make(map[key.Key]struct{}))
+}
+
+func exportNode(ctx context.Context, ds dag.DAGService, nd *dag.Node, w io.Writer, seen map[key.Key]struct{}) error {
+	for _, l := range nd.Links {
+		if _, ok := seen[key.Key(l.Hash)]; ok {
+			continue
+		}
+		child, err := l.GetNode(ctx, ds)
+		if err != nil {
+			return err
+		}
+		if err := exportNode(ctx, ds, child, w, seen); err != nil {
+			return err
+		}
+	}
+
+	data, err := nd.EncodeProtobuf(false)
+	if err != nil {
+		return err
+	}
+	mh, err := nd.Multihash()
+	if err != nil {
+		return err
+	}
+	seen[key.Key(mh)] = struct{}{}
+
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(len(mh)+len(data)))
+	for _, b := range [][]byte{prefix[:n], mh, data} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package coreunix
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	"gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+func TestExportDAG(t *testing.T) {
+	// the same data, exported from two nodes, gives the same bytes
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i % 251) // no two blocks alike
+	}
+	var exports [][]byte
+	var root key.Key
+	for i := 0; i < 2; i++ {
+		node := newTestNode(t)
+
+		adder, err := NewAdder(context.Background(), node, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.Chunker = "size-512"
+		nd, err := adder.add(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err = nd.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := ExportDAG(context.Background(), node.DAG, root, &buf); err != nil {
+			t.Fatal(err)
+		}
+		exports = append(exports, buf.Bytes())
+	}
+	if !bytes.Equal(exports[0], exports[1]) {
+		t.Fatal("exports of the same data differ")
+	}
+
+	// 20 leaves and their parent, the root last
+	r := bufio.NewReader(bytes.NewReader(exports[0]))
+	var last []byte
+	var sections int
+	for {
+		size, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		last = make([]byte, size)
+		if _, err := io.ReadFull(r, last); err != nil {
+			t.Fatal(err)
+		}
+		sections++
+	}
+	if sections != 21 {
+		t.Fatalf("expected 21 blocks, got %d", sections)
+	}
+	if !bytes.HasPrefix(last, []byte(root)) {
+		t.Fatal("expected the root to be exported last")
+	}
+}
@@ -0,0 +1,68 @@
+package coreunix
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ValidateGlob returns an error if pattern is not a valid glob as accepted
+// by the adder's Include and Exclude fields.
+func ValidateGlob(pattern string) error {
+	for _, seg := range strings.Split(pattern, "/") {
+		if seg == "**" {
+			continue
+		}
+		if _, err := path.Match(seg, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether the slash separated name matches pattern. In
+// addition to the syntax of path.Match, a "**" segment matches zero or more
+// path segments.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			pat = pat[1:]
+			if len(pat) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		ok, err := path.Match(pat[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+
+		pat, name = pat[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// relativeName strips the top-level directory from name, so that patterns
+// are anchored at the root of the add.
+func relativeName(name string) string {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) < 2 {
+		return name
+	}
+	return parts[1]
+}
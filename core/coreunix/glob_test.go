@@ -0,0 +1,29 @@
+package coreunix
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"*.log", "a.log", true},
+		{"*.log", "sub/a.log", false},
+		{"**/*.log", "a.log", true},
+		{"**/*.log", "sub/deeper/a.log", true},
+		{"sub/**", "sub/deeper/a.log", true},
+		{"sub/**/a.log", "sub/a.log", true},
+		{"sub/**/a.log", "other/a.log", false},
+		{"sub/*.txt", "sub/a.log", false},
+	}
+
+	for _, c := range cases {
+		if matchGlob(c.pattern, c.name) != c.match {
+			t.Errorf("matchGlob(%q, %q) != %v", c.pattern, c.name, c.match)
+		}
+	}
+
+	if err := ValidateGlob("[a-"); err == nil {
+		t.Error("expected malformed pattern to be rejected")
+	}
+}
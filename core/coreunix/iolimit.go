@@ -0,0 +1,33 @@
+package coreunix
+
+import (
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	iolimit "github.com/ipfs/go-ipfs/thirdparty/iolimit"
+	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+// limitedBlockstore holds a slot of limit for each write to the blockstore it
+// wraps, so that adds and GC together keep to the node's IOLimit.
+type limitedBlockstore struct {
+	bstore.Blockstore
+	ctx   context.Context
+	limit *iolimit.Limiter
+}
+
+func (l *limitedBlockstore) Put(b *blocks.Block) error {
+	if err := l.limit.Acquire(l.ctx); err != nil {
+		return err
+	}
+	defer l.limit.Release()
+	return l.Blockstore.Put(b)
+}
+
+// PutMany writes bs as one operation, with a single slot.
+func (l *limitedBlockstore) PutMany(bs []*blocks.Block) error {
+	if err := l.limit.Acquire(l.ctx); err != nil {
+		return err
+	}
+	defer l.limit.Release()
+	return l.Blockstore.PutMany(bs)
+}
@@ -0,0 +1,118 @@
+package coreunix
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+)
+
+// Journal is an append-only record of the files an add has completed, used to
+// resume an interrupted add without re-reading those files. Each entry is a
+// '<hash><TAB><path>' line, synced to disk before Record returns.
+type Journal struct {
+	lock sync.Mutex
+	f    *os.File
+	done map[string]key.Key
+}
+
+// OpenJournal opens the journal at path, creating it if needed, and loads the
+// entries of previous runs. A trailing partial entry, left by a crash during
+// Record, is discarded.
+func OpenJournal(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{f: f, done: make(map[string]key.Key)}
+	valid, err := j.load()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(valid, os.SEEK_SET); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// load reads the journal's entries and returns the length of the part of the
+// file made of complete entries.
+func (j *Journal) load() (int64, error) {
+	r := bufio.NewReader(j.f)
+	var valid int64
+	for line := 1; ; line++ {
+		text, err := r.ReadString('\n')
+		if err == io.EOF {
+			return valid, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		valid += int64(len(text))
+
+		parts := strings.SplitN(strings.TrimSuffix(text, "\n"), "\t", 2)
+		if len(parts) != 2 {
+			return 0, fmt.Errorf("journal line %d: expected '<hash><TAB><path>'", line)
+		}
+		k := key.B58KeyDecode(parts[0])
+		if k == "" {
+			return 0, fmt.Errorf("journal line %d: invalid hash %q", line, parts[0])
+		}
+		j.done[parts[1]] = k
+	}
+}
+
+// Lookup returns the key recorded for path, if any. Nothing is ever recorded
+// for the empty path of an unnamed entry.
+func (j *Journal) Lookup(path string) (key.Key, bool) {
+	if path == "" {
+		return "", false
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	k, ok := j.done[path]
+	return k, ok
+}
+
+// Record appends an entry for path and syncs it to disk. Unnamed entries,
+// whose path is empty, can't be told apart between runs, and are not recorded.
+func (j *Journal) Record(path string, k key.Key) error {
+	if path == "" {
+		return nil
+	}
+	if strings.ContainsAny(path, "\t\n") {
+		return fmt.Errorf("cannot journal path %q", path)
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\t%s\n", k.B58String(), path)
+	if _, err := j.f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	j.done[path] = k
+	return nil
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
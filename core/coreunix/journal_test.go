@@ -0,0 +1,67 @@
+package coreunix
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	u "gx/ipfs/QmZNVWh8LLjAavuQ2JXuFmuYH3C11xo988vSgp7UQrTRj1/go-ipfs-util"
+)
+
+func TestJournalReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := key.Key(u.Hash([]byte("a")))
+	if err := j.Record("dir/a", k); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash in the middle of writing the next entry
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("QmPartial\tdir/"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	j, err = OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := j.Lookup("dir/a"); !ok || got != k {
+		t.Fatalf("expected dir/a to be journaled as %s, got %s", k, got)
+	}
+	k2 := key.Key(u.Hash([]byte("b")))
+	if err := j.Record("dir/b", k2); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	j, err = OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if got, ok := j.Lookup("dir/b"); !ok || got != k2 {
+		t.Fatalf("expected dir/b to be journaled as %s, got %s", k2, got)
+	}
+	if len(j.done) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(j.done))
+	}
+}
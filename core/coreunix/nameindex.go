@@ -0,0 +1,68 @@
+package coreunix
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+)
+
+// NameIndex collects a '<hash><TAB><name>' line for each file an add reports,
+// in the order they are reported, so that hashes can be mapped back to the
+// names they were added under. Nothing is written until Commit.
+type NameIndex struct {
+	path string
+	buf  bytes.Buffer
+}
+
+// NewNameIndex returns an empty index to be written to the file at path.
+func NewNameIndex(path string) *NameIndex {
+	return &NameIndex{path: path}
+}
+
+func (x *NameIndex) record(name string, k key.Key) error {
+	if strings.ContainsAny(name, "\t\n") {
+		return fmt.Errorf("cannot index name %q", name)
+	}
+	fmt.Fprintf(&x.buf, "%s\t%s\n", k.B58String(), name)
+	return nil
+}
+
+// Commit writes the index to its file, replacing it. The index is written to
+// a temporary file next to it first, so readers of the file never see a
+// partial index.
+func (x *NameIndex) Commit() error {
+	return replaceFile(x.path, ".name-index-", x.buf.Bytes())
+}
+
+// replaceFile writes data to a temporary file named with prefix next to the
+// file at path, and then renames it to path, so that the file is replaced at
+// once.
+func replaceFile(path, prefix string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), prefix)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// TempFile creates the file readable by its owner alone
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
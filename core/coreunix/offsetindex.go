@@ -0,0 +1,75 @@
+package coreunix
+
+import (
+	"bytes"
+	"fmt"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+// BuildOffsetIndex walks the file rooted at root, as built by the adder, and
+// returns its offset index. The index is text, with a line
+//
+//	<offset> <size> <hash>
+//
+// for each node holding data of the file, in the order of that data in the
+// file: offset is where the node's own data starts in the file, size is its
+// length in bytes, and hash is the node's base58 hash. Nodes holding no data
+// of their own are left out, so a reader can find the node holding any byte
+// with a binary search over the lines, and fetch it directly.
+func BuildOffsetIndex(ctx context.Context, ds dag.DAGService, root *dag.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	var offset uint64
+	err := walkFileData(ctx, ds, root, func(k key.Key, size uint64) error {
+		fmt.Fprintf(&buf, "%d %d %s\n", offset, size, k.B58String())
+		offset += size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// walkFileData calls fn with the key and the size of the own data of each
+// node of the file rooted at root that holds any, in the order of that data
+// in the file.
+func walkFileData(ctx context.Context, ds dag.DAGService, root *dag.Node, fn func(k key.Key, size uint64) error) error {
+	var walk func(nd *dag.Node) error
+	walk = func(nd *dag.Node) error {
+		k, err := nd.Key()
+		if err != nil {
+			return err
+		}
+		pb, err := ft.FromBytes(nd.Data)
+		if err != nil {
+			return err
+		}
+		switch pb.GetType() {
+		case ft.TFile, ft.TRaw:
+		default:
+			return fmt.Errorf("%s is not a file", k.B58String())
+		}
+
+		// a node's own data comes before that of its children
+		if size := uint64(len(pb.Data)); size > 0 {
+			if err := fn(k, size); err != nil {
+				return err
+			}
+		}
+		for _, l := range nd.Links {
+			child, err := l.GetNode(ctx, ds)
+			if err != nil {
+				return err
+			}
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(root)
+}
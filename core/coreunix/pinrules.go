@@ -0,0 +1,81 @@
+package coreunix
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	"github.com/ipfs/go-ipfs/commands/files"
+	"github.com/ipfs/go-ipfs/pin"
+)
+
+// Pin modes of a PinRule.
+const (
+	PinRuleRecursive = "recursive"
+	PinRuleDirect    = "direct"
+	PinRuleNone      = "none"
+)
+
+// PinRule decides how the added files whose path, relative to the directory
+// being added, matches Pattern are pinned. Patterns are globs as accepted by
+// ValidateGlob.
+type PinRule struct {
+	Pattern string
+	Mode    string
+}
+
+// ParsePinRules reads pin rules, one '<pattern><TAB><mode>' per line, where
+// mode is recursive, direct or none. Empty lines and lines starting with '#'
+// are skipped.
+func ParsePinRules(r io.Reader) ([]PinRule, error) {
+	var rules []PinRule
+	scan := bufio.NewScanner(r)
+	for line := 1; scan.Scan(); line++ {
+		text := scan.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.Split(text, "\t")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("pin rules line %d: expected '<pattern><TAB><mode>'", line)
+		}
+		if err := ValidateGlob(parts[0]); err != nil {
+			return nil, fmt.Errorf("pin rules line %d: %s", line, err)
+		}
+		switch parts[1] {
+		case PinRuleRecursive, PinRuleDirect, PinRuleNone:
+		default:
+			return nil, fmt.Errorf("pin rules line %d: unknown mode %q, must be recursive, direct or none", line, parts[1])
+		}
+		rules = append(rules, PinRule{Pattern: parts[0], Mode: parts[1]})
+	}
+	return rules, scan.Err()
+}
+
+// pinByRules pins the object k, added for file, as the first of the adder's
+// PinRules matching the file says, and returns the mode used. Files no rule
+// matches are not pinned. The pins are flushed when the adder is closed.
+func (adder *Adder) pinByRules(file files.File, k key.Key) string {
+	mode := PinRuleNone
+	rel := relativeName(file.FileName())
+	for _, r := range adder.PinRules {
+		if matchGlob(r.Pattern, rel) {
+			mode = r.Mode
+			break
+		}
+	}
+
+	switch mode {
+	case PinRuleRecursive:
+		adder.node.Pinning.PinWithMode(k, pin.Recursive)
+	case PinRuleDirect:
+		adder.node.Pinning.PinWithMode(k, pin.Direct)
+	default:
+		return mode
+	}
+	adder.unflushed = true
+	return mode
+}
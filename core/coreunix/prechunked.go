@@ -0,0 +1,95 @@
+package coreunix
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/ipfs/go-ipfs/commands/files"
+	h "github.com/ipfs/go-ipfs/importer/helpers"
+)
+
+// AddPreChunked adds a single file whose data was split into chunks by some
+// other tool: each regular file of dir is one chunk, and the chunks follow
+// each other in the order of their names. The file is built over those
+// chunks with the adder's layout, as if its chunker had split the data there,
+// and is added under the name of dir. The entries of dir must come in name
+// order, as those of a directory read from disk do, and each chunk must hold
+// between 1 byte and helpers.BlockSizeLimit.
+func (adder *Adder) AddPreChunked(dir files.File) error {
+	if adder.unlocker == nil {
+		adder.unlocker = adder.node.Blockstore.PinLock()
+	}
+	if !dir.IsDirectory() {
+		return fmt.Errorf("%s is not a directory of chunks", dir.FileName())
+	}
+
+	path, err := adder.entryPath(dir)
+	if err != nil {
+		return err
+	}
+
+	spl := &chunkDirSplitter{dir: dir}
+	nd, blocks, depth, err := adder.layoutChunks(spl)
+	if err != nil {
+		return err
+	}
+	// the importer stops at the first error of the splitter, as at its end
+	if spl.err != nil {
+		return spl.err
+	}
+	if blocks == 0 {
+		return fmt.Errorf("%s holds no chunks", dir.FileName())
+	}
+	return adder.addNode(nd, path, &AddedObject{Blocks: blocks, Depth: depth})
+}
+
+// chunkDirSplitter is a chunk.Splitter returning each file of dir as a chunk.
+type chunkDirSplitter struct {
+	dir  files.File
+	last string // name of the previous chunk
+	err  error
+}
+
+func (s *chunkDirSplitter) NextBytes() ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	data, err := s.next()
+	if err != nil {
+		if err != io.EOF {
+			s.err = err
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *chunkDirSplitter) next() ([]byte, error) {
+	f, err := s.dir.NextFile()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	name := f.FileName()
+	if _, ok := f.(*files.Symlink); ok || f.IsDirectory() {
+		return nil, fmt.Errorf("chunk %s is not a regular file", name)
+	}
+	if s.last != "" && name <= s.last {
+		return nil, fmt.Errorf("chunk %s comes after %s, not in name order", name, s.last)
+	}
+	s.last = name
+
+	data, err := ioutil.ReadAll(io.LimitReader(f, int64(h.BlockSizeLimit)+1))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(data) == 0:
+		return nil, fmt.Errorf("chunk %s is empty", name)
+	case len(data) > h.BlockSizeLimit:
+		return nil, fmt.Errorf("chunk %s is larger than %d bytes", name, h.BlockSizeLimit)
+	}
+	return data, nil
+}
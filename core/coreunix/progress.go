@@ -0,0 +1,101 @@
+package coreunix
+
+import (
+	"container/list"
+
+	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+// CoalesceProgress returns a channel for an Adder to write its output to,
+// relaying all it is sent to out, which it closes once the returned channel
+// is closed and all was relayed. A slow reader of out doesn't hold the adder
+// up with progress updates: while out is full, a progress update of a file
+// replaces the one of the same file still waiting to be relayed. All other
+// objects, such as the added hashes, are relayed in order, and the adder
+// blocks once as many of them are waiting as out can buffer. A file's
+// progress is never relayed after its hash. Once ctx is done, nothing more is
+// relayed.
+func CoalesceProgress(ctx context.Context, out chan<- interface{}) chan interface{} {
+	in := make(chan interface{})
+	go coalesceProgress(ctx, in, out)
+	return in
+}
+
+func coalesceProgress(ctx context.Context, in <-chan interface{}, out chan<- interface{}) {
+	defer close(out)
+
+	// the most objects other than progress updates that may wait
+	max := cap(out)
+	if max < 1 {
+		max = 1
+	}
+
+	pending := list.New()
+	progress := make(map[string]*list.Element) // pending updates by file name
+	added := make(map[string]bool)             // files whose hash was queued
+	queued := 0                                // pending objects that aren't updates
+	for in != nil || pending.Len() > 0 {
+		// a nil channel never sends, so only send when something is pending
+		var send chan<- interface{}
+		var next interface{}
+		if front := pending.Front(); front != nil {
+			send = out
+			next = front.Value
+		}
+		// nor receives, so that the adder blocks while the queue is full
+		recv := in
+		if queued >= max {
+			recv = nil
+		}
+
+		select {
+		case v, ok := <-recv:
+			if !ok {
+				in = nil
+				continue
+			}
+			o, ok := v.(*AddedObject)
+			if !ok || !isProgress(o) {
+				if ok && o.Hash != "" {
+					// the file's pending update stays ahead of its hash,
+					// and no later one is relayed
+					delete(progress, o.Name)
+					added[o.Name] = true
+				}
+				pending.PushBack(v)
+				queued++
+				continue
+			}
+			if added[o.Name] {
+				continue
+			}
+			// move the file's update to the back, to keep the order of
+			// what is relayed
+			if e, ok := progress[o.Name]; ok {
+				pending.Remove(e)
+			}
+			progress[o.Name] = pending.PushBack(o)
+		case send <- next:
+			front := pending.Front()
+			if o, ok := next.(*AddedObject); ok && progress[o.Name] == front {
+				delete(progress, o.Name)
+			} else if !ok || !isProgress(o) {
+				queued--
+			}
+			pending.Remove(front)
+		case <-ctx.Done():
+			// nobody reads out anymore, let the adder finish
+			if in != nil {
+				for range in {
+				}
+			}
+			return
+		}
+	}
+}
+
+// isProgress reports whether o is a progress update, rather than an added
+// object or a summary.
+func isProgress(o *AddedObject) bool {
+	return o.Hash == "" && o.Summary == nil && o.Proof == nil && o.Block == ""
+}
@@ -0,0 +1,101 @@
+package coreunix
+
+import (
+	"testing"
+	"time"
+
+	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+func TestCoalesceProgress(t *testing.T) {
+	out := make(chan interface{})
+	in := CoalesceProgress(context.Background(), out)
+
+	// nothing reads out yet, so the updates pile up
+	for i := int64(1); i <= 100; i++ {
+		in <- &AddedObject{Name: "a", Bytes: i}
+	}
+	in <- &AddedObject{Name: "a", Hash: "QmA"}
+	go func() {
+		for i := int64(1); i <= 3; i++ {
+			in <- &AddedObject{Name: "b", Bytes: i}
+		}
+		// a stray update of a file that was added is not relayed
+		in <- &AddedObject{Name: "a", Bytes: 101}
+		in <- &AddedObject{Name: "b", Hash: "QmB"}
+		close(in)
+	}()
+
+	var got []*AddedObject
+	for v := range out {
+		got = append(got, v.(*AddedObject))
+	}
+	if len(got) < 3 {
+		t.Fatalf("expected at least 3 objects, got %d", len(got))
+	}
+	expected := []AddedObject{
+		{Name: "a", Bytes: 100},
+		{Name: "a", Hash: "QmA"},
+	}
+	for i, o := range expected {
+		if *got[i] != o {
+			t.Fatalf("object %d: expected %+v, got %+v", i, o, *got[i])
+		}
+	}
+	// b's updates may or may not be coalesced, depending on when out is read
+	var last int64
+	for _, o := range got[2 : len(got)-1] {
+		if o.Name != "b" || o.Hash != "" || o.Bytes <= last {
+			t.Fatalf("expected increasing updates of b, got %+v", *o)
+		}
+		last = o.Bytes
+	}
+	if o := got[len(got)-1]; *o != (AddedObject{Name: "b", Hash: "QmB"}) {
+		t.Fatalf("expected b's hash last, got %+v", *o)
+	}
+}
+
+func TestCoalesceProgressBlocks(t *testing.T) {
+	out := make(chan interface{})
+	in := CoalesceProgress(context.Background(), out)
+	defer func() {
+		close(in)
+		for range out {
+		}
+	}()
+
+	in <- &AddedObject{Name: "a", Hash: "QmA"}
+	select {
+	case in <- &AddedObject{Name: "b", Hash: "QmB"}:
+		t.Fatal("expected the adder to block while out is full")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	<-out
+	select {
+	case in <- &AddedObject{Name: "b", Hash: "QmB"}:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the adder to go on once out was read")
+	}
+}
+
+func TestCoalesceProgressCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan interface{})
+	in := CoalesceProgress(ctx, out)
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			in <- &AddedObject{Name: "a", Hash: "QmA"}
+		}
+		close(in)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writes blocked after the context was canceled")
+	}
+}
@@ -0,0 +1,81 @@
+package coreunix
+
+import (
+	"errors"
+	"fmt"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+// ErrOffsetOutOfRange is returned by ProofForOffset for an offset at or past
+// the end of the file.
+var ErrOffsetOutOfRange = errors.New("offset is past the end of the file")
+
+// Proof is the Merkle path from the root of a unixfs file to the node holding
+// the byte at Offset. Anyone trusting the root can check the leaf's bytes by
+// fetching the nodes of Hashes in order, checking each against its hash and
+// that it links to the next one, without fetching the rest of the file.
+type Proof struct {
+	Offset uint64
+	Start  uint64   // offset in the file of the first byte of the leaf's data
+	Size   uint64   // bytes of data in the leaf
+	Hashes []string // root first, the leaf last
+}
+
+// ProofForOffset walks the file rooted at root, as built by the adder, down to
+// the node whose own data holds the byte at offset, and returns the path to it.
+func ProofForOffset(ctx context.Context, ds dag.DAGService, root *dag.Node, offset uint64) (*Proof, error) {
+	proof := &Proof{Offset: offset}
+	nd := root
+	left := offset // relative to the start of nd
+	for {
+		k, err := nd.Key()
+		if err != nil {
+			return nil, err
+		}
+		proof.Hashes = append(proof.Hashes, k.B58String())
+
+		pb, err := ft.FromBytes(nd.Data)
+		if err != nil {
+			return nil, err
+		}
+		switch pb.GetType() {
+		case ft.TFile, ft.TRaw:
+		default:
+			return nil, fmt.Errorf("%s is not a file", k.B58String())
+		}
+		if left >= pb.GetFilesize() {
+			return nil, ErrOffsetOutOfRange
+		}
+
+		// a node's own data comes before that of its children
+		if left < uint64(len(pb.Data)) {
+			proof.Start = offset - left
+			proof.Size = uint64(len(pb.Data))
+			return proof, nil
+		}
+		left -= uint64(len(pb.Data))
+
+		if len(pb.Blocksizes) != len(nd.Links) {
+			return nil, fmt.Errorf("%s has %d links for %d blocks", k.B58String(), len(nd.Links), len(pb.Blocksizes))
+		}
+		child := -1
+		for i, size := range pb.Blocksizes {
+			if left < size {
+				child = i
+				break
+			}
+			left -= size
+		}
+		if child < 0 {
+			return nil, fmt.Errorf("%s: block sizes do not add up to its size", k.B58String())
+		}
+
+		nd, err = nd.Links[child].GetNode(ctx, ds)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package coreunix
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/blocks/key"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	"gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+func TestProofForOffset(t *testing.T) {
+	node := newTestNode(t)
+
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	adder, err := NewAdder(context.Background(), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adder.Close()
+	adder.Chunker = "size-16"
+	adder.MaxLinks = 3 // several levels of intermediate nodes
+	root, err := adder.add(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rk, err := root.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, offset := range []uint64{0, 15, 16, 500, 999} {
+		proof, err := ProofForOffset(context.Background(), node.DAG, root, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.Hashes[0] != rk.B58String() {
+			t.Fatalf("offset %d: proof starts at %s, not the root", offset, proof.Hashes[0])
+		}
+		if len(proof.Hashes) < 3 {
+			t.Fatalf("offset %d: expected a proof through intermediate nodes, got %v", offset, proof.Hashes)
+		}
+
+		// check the path the way a verifier would
+		for i := 0; i < len(proof.Hashes)-1; i++ {
+			nd, err := node.DAG.Get(context.Background(), key.B58KeyDecode(proof.Hashes[i]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			linked := false
+			for _, l := range nd.Links {
+				linked = linked || key.Key(l.Hash) == key.B58KeyDecode(proof.Hashes[i+1])
+			}
+			if !linked {
+				t.Fatalf("offset %d: %s does not link to %s", offset, proof.Hashes[i], proof.Hashes[i+1])
+			}
+		}
+
+		leaf, err := node.DAG.Get(context.Background(), key.B58KeyDecode(proof.Hashes[len(proof.Hashes)-1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		leafData, err := ft.UnwrapData(leaf.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint64(len(leafData)) != proof.Size || offset < proof.Start || offset >= proof.Start+proof.Size {
+			t.Fatalf("offset %d: leaf covers %d bytes from %d", offset, proof.Size, proof.Start)
+		}
+		if !bytes.Equal(leafData, data[proof.Start:proof.Start+proof.Size]) {
+			t.Fatalf("offset %d: leaf data does not match the file at %d", offset, proof.Start)
+		}
+	}
+
+	if _, err := ProofForOffset(context.Background(), node.DAG, root, uint64(len(data))); err != ErrOffsetOutOfRange {
+		t.Fatalf("expected ErrOffsetOutOfRange, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package coreunix
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	backoff "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/cenkalti/backoff"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+)
+
+// retryDAGService retries failed writes to the DAGService it wraps, up to
+// retries times with an exponential backoff, so that a transient datastore
+// error does not abort a long add. Errors that retrying can't fix, such as a
+// full disk, are returned right away.
+type retryDAGService struct {
+	dag.DAGService
+	retries    int
+	newBackOff func() backoff.BackOff
+}
+
+func newRetryDAGService(ds dag.DAGService, retries int) *retryDAGService {
+	return &retryDAGService{
+		DAGService: ds,
+		retries:    retries,
+		newBackOff: func() backoff.BackOff { return backoff.NewExponentialBackOff() },
+	}
+}
+
+func (r *retryDAGService) Add(nd *dag.Node) (key.Key, error) {
+	var b backoff.BackOff
+	for i := 0; ; i++ {
+		k, err := r.DAGService.Add(nd)
+		if err == nil || i == r.retries || isPermanent(err) {
+			return k, err
+		}
+
+		if b == nil {
+			b = r.newBackOff()
+		}
+		wait := b.NextBackOff()
+		if wait == backoff.Stop {
+			return k, err
+		}
+		log.Warningf("writing block failed, retrying in %s: %s", wait, err)
+		time.Sleep(wait)
+	}
+}
+
+// isPermanent reports whether err is a write error that won't go away by
+// retrying.
+func isPermanent(err error) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+
+	switch err {
+	case syscall.ENOSPC, syscall.EROFS, syscall.EACCES, syscall.EPERM:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package coreunix
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	backoff "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/cenkalti/backoff"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+)
+
+// flakyDAGService fails the first fails writes with err.
+type flakyDAGService struct {
+	dag.DAGService
+	fails int
+	err   error
+	calls int
+}
+
+func (f *flakyDAGService) Add(nd *dag.Node) (key.Key, error) {
+	f.calls++
+	if f.calls <= f.fails {
+		return "", f.err
+	}
+	return f.DAGService.Add(nd)
+}
+
+func TestRetryDAGService(t *testing.T) {
+	nd := &dag.Node{Data: []byte("block")}
+	zero := func() backoff.BackOff { return &backoff.ZeroBackOff{} }
+
+	flaky := &flakyDAGService{DAGService: mdtest.Mock(), fails: 2, err: errors.New("transient")}
+	rds := newRetryDAGService(flaky, 2)
+	rds.newBackOff = zero
+	if _, err := rds.Add(nd); err != nil {
+		t.Fatal(err)
+	}
+
+	flaky = &flakyDAGService{DAGService: mdtest.Mock(), fails: 3, err: errors.New("transient")}
+	rds = newRetryDAGService(flaky, 2)
+	rds.newBackOff = zero
+	if _, err := rds.Add(nd); err == nil {
+		t.Fatal("expected the write to fail after 2 retries")
+	}
+	if flaky.calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", flaky.calls)
+	}
+
+	full := &os.PathError{Op: "write", Path: "blk", Err: syscall.ENOSPC}
+	flaky = &flakyDAGService{DAGService: mdtest.Mock(), fails: 1, err: full}
+	rds = newRetryDAGService(flaky, 2)
+	rds.newBackOff = zero
+	if _, err := rds.Add(nd); err != full {
+		t.Fatalf("expected the out of space error, got %v", err)
+	}
+	if flaky.calls != 1 {
+		t.Fatalf("expected a permanent error not to be retried, got %d attempts", flaky.calls)
+	}
+}
@@ -0,0 +1,65 @@
+package coreunix
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// sniffLen is the number of bytes http.DetectContentType considers.
+const sniffLen = 512
+
+// RejectedTypeError is returned when the detected content type of a file
+// being added matches one of the adder's RejectTypes.
+type RejectedTypeError struct {
+	Path string
+	Type string
+}
+
+func (e *RejectedTypeError) Error() string {
+	return fmt.Sprintf("%s: content type %s is rejected", e.Path, e.Type)
+}
+
+// ValidateTypePattern returns an error if pattern is not a valid content
+// type pattern as accepted by the adder's RejectTypes, such as "image/png" or
+// "video/*".
+func ValidateTypePattern(pattern string) error {
+	if !strings.Contains(pattern, "/") {
+		return fmt.Errorf("invalid content type pattern %q: expected type/subtype", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid content type pattern %q: %s", pattern, err)
+	}
+	return nil
+}
+
+// sniffType detects the content type of what r reads, without its
+// parameters (e.g. "text/plain"), and returns a reader that still reads all
+// of it, the sniffed bytes included.
+func sniffType(r io.Reader) (string, io.Reader, error) {
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+	buf = buf[:n]
+
+	typ := http.DetectContentType(buf)
+	if i := strings.Index(typ, ";"); i >= 0 {
+		typ = typ[:i]
+	}
+	return strings.TrimSpace(typ), io.MultiReader(bytes.NewReader(buf), r), nil
+}
+
+// rejectsType reports whether typ matches any of the adder's RejectTypes.
+func (adder *Adder) rejectsType(typ string) bool {
+	for _, p := range adder.RejectTypes {
+		if ok, _ := path.Match(p, typ); ok {
+			return true
+		}
+	}
+	return false
+}
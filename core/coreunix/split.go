@@ -0,0 +1,78 @@
+package coreunix
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+)
+
+// SplitManifestLink is the link name of the SplitManifest in the directory of
+// a file added with Split.
+const SplitManifestLink = "manifest"
+
+// SplitManifest describes the parts of a file added with Split. Its JSON is
+// stored next to the parts.
+type SplitManifest struct {
+	Size  int64    // of the original file
+	Parts []string // link names of the parts, in order
+}
+
+func splitPartName(i int) string {
+	return fmt.Sprintf("part-%06d", i)
+}
+
+// splitLayout imports reader as a directory of files of Split bytes each, the
+// last one possibly shorter, and a SplitManifest. Each part is a file of its
+// own, fetched without the others. The blocks and depth returned are those of
+// all the parts, seen below the directory.
+func (adder Adder) splitLayout(reader io.Reader) (*dag.Node, int64, int, error) {
+	br := bufio.NewReader(reader)
+	dir := newDirNode()
+	var manifest SplitManifest
+	var blocks int64
+	var depth int
+	for i := 0; ; i++ {
+		if _, err := br.Peek(1); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, 0, err
+		}
+
+		part := &io.LimitedReader{R: br, N: adder.Split}
+		nd, b, d, err := adder.layout(part)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		name := splitPartName(i)
+		if err := dir.AddNodeLink(name, nd); err != nil {
+			return nil, 0, 0, err
+		}
+		manifest.Size += adder.Split - part.N
+		manifest.Parts = append(manifest.Parts, name)
+		blocks += b
+		if d+1 > depth {
+			depth = d + 1
+		}
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	mnode, err := adder.add(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if err := dir.AddNodeLink(SplitManifestLink, mnode); err != nil {
+		return nil, 0, 0, err
+	}
+
+	if _, err := adder.dagserv.Add(dir); err != nil {
+		return nil, 0, 0, err
+	}
+	return dir, blocks, depth, nil
+}
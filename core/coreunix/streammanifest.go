@@ -0,0 +1,67 @@
+package coreunix
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+// StreamManifest collects, for each regular file an add reports, the blocks
+// a receiver has to fetch, in order, to rebuild the file as a stream. Each
+// file gets a line
+//
+//	<root hash><TAB><name><TAB><hash>:<size> <hash>:<size> ...
+//
+// listing, in the order of the file's bytes, the base58 hash of every node
+// holding data of the file and the number of those bytes it holds, as in the
+// offset index (see BuildOffsetIndex). The list of an empty file is empty.
+// Directories and symlinks get no line. Nothing is written until Commit.
+type StreamManifest struct {
+	path string
+	buf  bytes.Buffer
+}
+
+// NewStreamManifest returns an empty manifest to be written to the file at
+// path.
+func NewStreamManifest(path string) *StreamManifest {
+	return &StreamManifest{path: path}
+}
+
+func (m *StreamManifest) record(ctx context.Context, ds dag.DAGService, name string, root *dag.Node) error {
+	if strings.ContainsAny(name, "\t\n") {
+		return fmt.Errorf("cannot list name %q in the stream manifest", name)
+	}
+	rk, err := root.Key()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		// unnamed files are added under their hash
+		name = rk.B58String()
+	}
+
+	var line bytes.Buffer
+	fmt.Fprintf(&line, "%s\t%s\t", rk.B58String(), name)
+	sep := ""
+	err = walkFileData(ctx, ds, root, func(k key.Key, size uint64) error {
+		fmt.Fprintf(&line, "%s%s:%d", sep, k.B58String(), size)
+		sep = " "
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	line.WriteByte('\n')
+	m.buf.Write(line.Bytes())
+	return nil
+}
+
+// Commit writes the manifest to its file, replacing it at once, like
+// NameIndex.Commit.
+func (m *StreamManifest) Commit() error {
+	return replaceFile(m.path, ".stream-manifest-", m.buf.Bytes())
+}
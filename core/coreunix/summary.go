@@ -0,0 +1,101 @@
+package coreunix
+
+import (
+	"io"
+	"sync"
+
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+)
+
+// SizeBuckets are the upper bounds, exclusive, of the buckets of the file size
+// histogram of an AddSummary: under 1KiB, under 1MiB and under 100MiB. One more
+// bucket counts the files of 100MiB and up.
+var SizeBuckets = []int64{1 << 10, 1 << 20, 100 << 20}
+
+// AddSummary sums up the regular files added by an Adder with Stats set, and
+// the blocks written for them.
+type AddSummary struct {
+	Files     int64
+	Bytes     int64
+	Histogram []int64 // number of files in each of the SizeBuckets
+
+	Blocks     int64 // written, whether new or not
+	Existing   int64 // of the Blocks, those the blockstore already had
+	BytesSaved int64 // in the Existing blocks, not stored again
+}
+
+func newAddSummary() *AddSummary {
+	return &AddSummary{Histogram: make([]int64, len(SizeBuckets)+1)}
+}
+
+func (s *AddSummary) addFile(size int64) {
+	s.Files++
+	s.Bytes += size
+
+	i := 0
+	for i < len(SizeBuckets) && size >= SizeBuckets[i] {
+		i++
+	}
+	s.Histogram[i]++
+}
+
+func (s *AddSummary) addBlock(size int, existed bool) {
+	s.Blocks++
+	if existed {
+		s.Existing++
+		s.BytesSaved += int64(size)
+	}
+}
+
+// statsBlockstore counts the blocks written to the blockstore it wraps in the
+// summary of its adder, if the adder has Stats set. The workers of the adder's
+// RecursiveWorkers write blocks at once, so they are counted under a lock; the
+// files are counted on the adder's goroutine alone, in fields of their own.
+type statsBlockstore struct {
+	bstore.Blockstore
+	adder *Adder
+	mu    sync.Mutex
+}
+
+func (s *statsBlockstore) Put(b *blocks.Block) error {
+	if err := s.count(b); err != nil {
+		return err
+	}
+	return s.Blockstore.Put(b)
+}
+
+func (s *statsBlockstore) PutMany(bs []*blocks.Block) error {
+	for _, b := range bs {
+		if err := s.count(b); err != nil {
+			return err
+		}
+	}
+	return s.Blockstore.PutMany(bs)
+}
+
+func (s *statsBlockstore) count(b *blocks.Block) error {
+	if !s.adder.Stats {
+		return nil
+	}
+	has, err := s.Has(b.Key())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.adder.countBlock(len(b.Data), has)
+	s.mu.Unlock()
+	return nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,22 @@
+package coreunix
+
+import (
+	"testing"
+)
+
+func TestAddSummaryBuckets(t *testing.T) {
+	s := newAddSummary()
+	for _, size := range []int64{0, 1023, 1024, 1<<20 - 1, 1 << 20, 100 << 20, 1 << 40} {
+		s.addFile(size)
+	}
+
+	expected := []int64{2, 2, 1, 2}
+	for i, n := range expected {
+		if s.Histogram[i] != n {
+			t.Errorf("bucket %d: expected %d files, got %d", i, n, s.Histogram[i])
+		}
+	}
+	if s.Files != 7 {
+		t.Errorf("expected 7 files, got %d", s.Files)
+	}
+}
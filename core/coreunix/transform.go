@@ -0,0 +1,58 @@
+package coreunix
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+var transforms = map[string]func(io.Reader) io.Reader{
+	"crlf-to-lf": newCRLFReader,
+}
+
+// NewTransform returns the named content transform, which wraps a file's
+// reader so that the transformed bytes are added instead. The only transform
+// is crlf-to-lf, which turns CRLF line endings into LF.
+func NewTransform(name string) (func(io.Reader) io.Reader, error) {
+	t, ok := transforms[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown transform %q", name)
+	}
+	return t, nil
+}
+
+// crlfReader drops every '\r' that is followed by '\n'.
+type crlfReader struct {
+	r *bufio.Reader
+}
+
+func newCRLFReader(r io.Reader) io.Reader {
+	return &crlfReader{r: bufio.NewReader(r)}
+}
+
+func (c *crlfReader) Read(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		// don't block for more input once we have something to return
+		if n > 0 && c.r.Buffered() == 0 {
+			break
+		}
+
+		b, err := c.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && n > 0 {
+				return n, nil
+			}
+			return n, err
+		}
+
+		if b == '\r' {
+			if next, err := c.r.Peek(1); err == nil && next[0] == '\n' {
+				continue
+			}
+		}
+		p[n] = b
+		n++
+	}
+	return n, nil
+}
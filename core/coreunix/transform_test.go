@@ -0,0 +1,31 @@
+package coreunix
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+func TestCRLFTransform(t *testing.T) {
+	transform, err := NewTransform("crlf-to-lf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := []byte("a\r\nb\rc\r\n\r\nd\r")
+	expected := []byte("a\nb\rc\n\nd\r")
+
+	// one byte at a time, so that every CRLF is split across reads
+	out, err := ioutil.ReadAll(transform(iotest.OneByteReader(bytes.NewReader(in))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, expected) {
+		t.Fatalf("expected %q, got %q", expected, out)
+	}
+
+	if _, err := NewTransform("rot13"); err == nil {
+		t.Fatal("expected an error for an unknown transform")
+	}
+}
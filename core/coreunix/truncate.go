@@ -0,0 +1,82 @@
+package coreunix
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-ipfs/commands/files"
+)
+
+// What an Adder does with a file that shrank or vanished while it was read,
+// see Adder.OnTruncate.
+const (
+	TruncateError   = "error"   // fail the add
+	TruncateSkip    = "skip"    // leave the file out of the add
+	TruncatePartial = "partial" // add the bytes read before the file ended
+)
+
+// ValidateOnTruncate returns an error unless mode is one of TruncateError,
+// TruncateSkip and TruncatePartial.
+func ValidateOnTruncate(mode string) error {
+	switch mode {
+	case TruncateError, TruncateSkip, TruncatePartial:
+		return nil
+	}
+	return fmt.Errorf("unsupported truncation mode %q, must be error, skip or partial", mode)
+}
+
+// TruncatedError describes a file that ended before the size it had when
+// the add opened it, or that could no longer be read.
+type TruncatedError struct {
+	Path string
+	Read int64 // bytes read before the file ended
+	Size int64 // when the file was opened, -1 if unknown
+	Err  error // io.ErrUnexpectedEOF if the file ended early
+}
+
+func (e *TruncatedError) Error() string {
+	if e.Err == io.ErrUnexpectedEOF {
+		return fmt.Sprintf("%s: file ended after %d of %d bytes", e.Path, e.Read, e.Size)
+	}
+	return fmt.Sprintf("%s: read failed after %d bytes: %s", e.Path, e.Read, e.Err)
+}
+
+// truncateReader reads a file, and ends it at the first read error, or early
+// end, which it keeps for the adder to handle. The importer stops at any read
+// error without reporting it, and drops the bytes of the block it was filling.
+type truncateReader struct {
+	r    io.Reader
+	path string
+	size int64
+	read int64
+	err  *TruncatedError
+}
+
+// newTruncateReader returns a truncateReader of r, which reads file. An
+// early end is detected for regular files only, which have a known size.
+func newTruncateReader(r io.Reader, file files.File, path string) *truncateReader {
+	t := &truncateReader{r: r, path: path, size: -1}
+	if sf, ok := file.(files.StatFile); ok {
+		if st := sf.Stat(); st != nil && st.Mode().IsRegular() {
+			t.size = st.Size()
+		}
+	}
+	return t
+}
+
+func (t *truncateReader) Read(p []byte) (int, error) {
+	if t.err != nil {
+		return 0, io.EOF
+	}
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+	switch {
+	case err == io.EOF && t.size >= 0 && t.read < t.size:
+		t.err = &TruncatedError{Path: t.path, Read: t.read, Size: t.size, Err: io.ErrUnexpectedEOF}
+	case err != nil && err != io.EOF:
+		t.err = &TruncatedError{Path: t.path, Read: t.read, Size: t.size, Err: err}
+		// let the importer finish with what was read
+		err = io.EOF
+	}
+	return n, err
+}
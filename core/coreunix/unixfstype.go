@@ -0,0 +1,24 @@
+package coreunix
+
+import (
+	"fmt"
+
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	ftpb "github.com/ipfs/go-ipfs/unixfs/pb"
+)
+
+// unixfsTypes are the types the leaves of an added file can be given. The
+// other unixfs types don't describe file data.
+var unixfsTypes = map[string]ftpb.Data_DataType{
+	"file": ft.TFile,
+	"raw":  ft.TRaw,
+}
+
+// ValidateUnixfsType returns an error unless name is a unixfs type the adder
+// can give the leaves of a file: file or raw.
+func ValidateUnixfsType(name string) error {
+	if _, ok := unixfsTypes[name]; !ok {
+		return fmt.Errorf("unsupported unixfs type %q, must be file or raw", name)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package coreunix
+
+import (
+	"io"
+	"os"
+
+	"github.com/ipfs/go-ipfs/commands/files"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+)
+
+// importJob is a local file being imported by a worker, see importAsync.
+type importJob struct {
+	done chan struct{} // closed once the import ends
+	err  error
+	link func() error // links the imported file in, set unless err is
+}
+
+// importAsync imports reader, which reads the local file f, on a worker, and
+// closes f once done. The walk of the add goes on meanwhile, so that files of
+// several directories are hashed at once. The imported files are linked in,
+// and reported, by link on the adder's goroutine, in the order they were
+// walked, which keeps the output of the add coherent. No more than
+// RecursiveWorkers files are imported at once: if all workers are busy, the
+// oldest import is waited for and linked first.
+//
+// The walk itself stays on the adder's goroutine, and so does all else the
+// adder does with the files; only the importers run on the workers, along with
+// the readers of the files. Files that are not local, such as those streamed
+// to the daemon, must be read in the order they come, and are imported by
+// addFile right away. So are all files of an adder of NewCheckAdder, as it
+// tells whether each file was present from the blocks written for it alone.
+func (adder *Adder) importAsync(f *os.File, reader io.Reader, layout func(io.Reader) (*dag.Node, int64, int, error), link func(*dag.Node, int64, int) error) error {
+	for len(adder.jobs) >= adder.RecursiveWorkers {
+		if err := adder.linkNext(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	job := &importJob{done: make(chan struct{})}
+	adder.jobs = append(adder.jobs, job)
+	go func() {
+		defer close(job.done)
+		defer f.Close()
+
+		nd, blocks, depth, err := layout(reader)
+		if err != nil {
+			job.err = err
+			return
+		}
+		job.link = func() error { return link(nd, blocks, depth) }
+	}()
+	return nil
+}
+
+// linkNext waits for the oldest import to end, and links its file in.
+func (adder *Adder) linkNext() error {
+	job := adder.jobs[0]
+	adder.jobs = adder.jobs[1:]
+
+	<-job.done
+	if job.err != nil {
+		return job.err
+	}
+	return job.link()
+}
+
+// waitImports waits for all the imports started by importAsync, and links
+// their files in. If one fails, the others are waited for, but not linked.
+func (adder *Adder) waitImports() error {
+	for len(adder.jobs) > 0 {
+		if err := adder.linkNext(); err != nil {
+			adder.abortImports()
+			return err
+		}
+	}
+	return nil
+}
+
+// abortImports waits for all the imports started by importAsync, without
+// linking their files in.
+func (adder *Adder) abortImports() {
+	for _, job := range adder.jobs {
+		<-job.done
+	}
+	adder.jobs = nil
+}
+
+// openLocal opens the local file f was read from again, so that a worker
+// reads it through a descriptor of its own. It returns nil if f is not a
+// regular file read from the local filesystem, or if the file now at f's path
+// is not the one f was opened from.
+func openLocal(f files.File) *os.File {
+	sf, ok := f.(files.StatFile)
+	if !ok || sf.Stat() == nil || !sf.Stat().Mode().IsRegular() || f.FullPath() == "" {
+		return nil
+	}
+
+	osf, err := os.Open(f.FullPath())
+	if err != nil {
+		return nil
+	}
+	st, err := osf.Stat()
+	if err != nil || !os.SameFile(st, sf.Stat()) {
+		osf.Close()
+		return nil
+	}
+	return osf
+}
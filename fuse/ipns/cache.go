@@ -0,0 +1,71 @@
+package ipns
+
+import (
+	"sync"
+	"time"
+
+	path "github.com/ipfs/go-ipfs/path"
+)
+
+// DefaultNegativeCacheTTL is how long the mount answers ENOENT for an ipns
+// name that failed to resolve before trying it again. It is kept short, so
+// that a name published meanwhile shows up promptly.
+var DefaultNegativeCacheTTL = 5 * time.Second
+
+// resolveCache caches the outcome of resolving names through the mount root
+// for a while. The mount only caches failed resolutions, the name system
+// already caching the successful ones.
+type resolveCache struct {
+	lk      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	val path.Path
+	eol time.Time
+}
+
+func newResolveCache(ttl time.Duration) *resolveCache {
+	return &resolveCache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+func (c *resolveCache) get(name string) (path.Path, bool) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	e, ok := c.entries[name]
+	if !ok {
+		return "", false
+	}
+
+	if time.Now().After(e.eol) {
+		delete(c.entries, name)
+		return "", false
+	}
+	return e.val, true
+}
+
+func (c *resolveCache) put(name string, p path.Path) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.entries[name] = cacheEntry{
+		val: p,
+		eol: time.Now().Add(c.ttl),
+	}
+}
+
+// invalidate drops the cached resolution of name, returning whether there
+// was one.
+func (c *resolveCache) invalidate(name string) bool {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	_, ok := c.entries[name]
+	delete(c.entries, name)
+	return ok
+}
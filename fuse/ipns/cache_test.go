@@ -0,0 +1,34 @@
+package ipns
+
+import (
+	"testing"
+	"time"
+
+	path "github.com/ipfs/go-ipfs/path"
+)
+
+func TestResolveCache(t *testing.T) {
+	c := newResolveCache(time.Millisecond * 50)
+	p := path.Path("/ipfs/QmWLdkp93sNxGRjnFHPaYg8tCQ35NBY3XPn6KiETd3Z4WR")
+
+	c.put("a", p)
+	c.put("b", p)
+	if v, ok := c.get("a"); !ok || v != p {
+		t.Fatal("expected cached value for a")
+	}
+
+	if !c.invalidate("a") {
+		t.Fatal("expected a to have been cached")
+	}
+	if _, ok := c.get("a"); ok {
+		t.Fatal("a should have been invalidated")
+	}
+	if _, ok := c.get("b"); !ok {
+		t.Fatal("invalidating a should not affect b")
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	if _, ok := c.get("b"); ok {
+		t.Fatal("b should have expired")
+	}
+}
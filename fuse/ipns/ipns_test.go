@@ -11,11 +11,14 @@ import (
 	"sync"
 	"testing"
 
+	fuse "github.com/ipfs/go-ipfs/Godeps/_workspace/src/bazil.org/fuse"
 	fstest "github.com/ipfs/go-ipfs/Godeps/_workspace/src/bazil.org/fuse/fs/fstestutil"
 	racedet "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-detect-race"
 
+	key "github.com/ipfs/go-ipfs/blocks/key"
 	core "github.com/ipfs/go-ipfs/core"
 	namesys "github.com/ipfs/go-ipfs/namesys"
+	path "github.com/ipfs/go-ipfs/path"
 	offroute "github.com/ipfs/go-ipfs/routing/offline"
 	ci "github.com/ipfs/go-ipfs/thirdparty/testutil/ci"
 	u "gx/ipfs/QmZNVWh8LLjAavuQ2JXuFmuYH3C11xo988vSgp7UQrTRj1/go-ipfs-util"
@@ -484,3 +487,49 @@ func TestMultiWrite(t *testing.T) {
 		t.Fatal("File on disk did not match bytes written")
 	}
 }
+
+func TestRefresh(t *testing.T) {
+	node, err := core.NewNode(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node.LoadPrivateKey(); err != nil {
+		t.Fatal(err)
+	}
+	node.Routing = offroute.NewOfflineRouter(node.Repo.Datastore(), node.PrivateKey)
+	// unlike the mount tests, have the name system cache resolutions
+	node.Namesys = namesys.NewNameSystem(node.Routing, node.Repo.Datastore(), 16)
+
+	root := &Root{Ipfs: node, IpfsRoot: "/ipfs"}
+	name := node.Identity.Pretty()
+	lookup := func() string {
+		nd, err := root.Lookup(context.Background(), &fuse.LookupRequest{Name: name}, &fuse.LookupResponse{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nd.(*Link).Target
+	}
+	publish := func(data string) string {
+		k := key.Key(u.Hash([]byte(data)))
+		if err := node.Namesys.Publish(context.Background(), node.PrivateKey, path.FromKey(k)); err != nil {
+			t.Fatal(err)
+		}
+		return "/ipfs/" + k.B58String()
+	}
+
+	first := publish("first")
+	if got := lookup(); got != first {
+		t.Fatalf("expected %s, got %s", first, got)
+	}
+
+	second := publish("second")
+	if got := lookup(); got != first {
+		t.Fatalf("expected the resolution of %s to be cached, got %s", name, got)
+	}
+	if !root.Refresh("/ipns/" + name) {
+		t.Fatalf("expected %s to be cached", name)
+	}
+	if got := lookup(); got != second {
+		t.Fatalf("expected %s after a refresh, got %s", second, got)
+	}
+}
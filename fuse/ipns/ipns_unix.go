@@ -8,6 +8,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	fuse "github.com/ipfs/go-ipfs/Godeps/_workspace/src/bazil.org/fuse"
 	fs "github.com/ipfs/go-ipfs/Godeps/_workspace/src/bazil.org/fuse/fs"
@@ -18,6 +20,7 @@ import (
 	core "github.com/ipfs/go-ipfs/core"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	mfs "github.com/ipfs/go-ipfs/mfs"
+	namesys "github.com/ipfs/go-ipfs/namesys"
 	path "github.com/ipfs/go-ipfs/path"
 	ft "github.com/ipfs/go-ipfs/unixfs"
 	ci "gx/ipfs/QmNefBbWHR9JEiP3KDVqZsBLQVRmH3GBG2D2Ke24SsFqfW/go-libp2p/p2p/crypto"
@@ -78,8 +81,16 @@ type Root struct {
 	Roots     map[string]*keyRoot
 
 	LocalLinks map[string]*Link
+
+	failed         *resolveCache // names that didn't resolve, nil to disable
+	resolveTimeout time.Duration
+	resolveRetries int
 }
 
+// resolveRetryDelay is the wait before the first retry of a failed name
+// resolution. It doubles for each further retry.
+const resolveRetryDelay = 200 * time.Millisecond
+
 func ipnsPubFunc(ipfs *core.IpfsNode, k ci.PrivKey) mfs.PubFunc {
 	return func(ctx context.Context, key key.Key) error {
 		return ipfs.Namesys.Publish(ctx, k, path.FromKey(key))
@@ -158,6 +169,7 @@ func CreateRoot(ipfs *core.IpfsNode, keys map[string]ci.PrivKey, ipfspath, ipnsp
 		LocalDirs:  ldirs,
 		LocalLinks: links,
 		Roots:      roots,
+		failed:     newResolveCache(DefaultNegativeCacheTTL),
 	}, nil
 }
 
@@ -169,7 +181,8 @@ func (*Root) Attr(ctx context.Context, a *fuse.Attr) error {
 }
 
 // Lookup performs a lookup under this node.
-func (s *Root) Lookup(ctx context.Context, name string) (fs.Node, error) {
+func (s *Root) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	name := req.Name
 	switch name {
 	case "mach_kernel", ".hidden", "._.":
 		// Just quiet some log noise on OS X.
@@ -192,10 +205,33 @@ func (s *Root) Lookup(ctx context.Context, name string) (fs.Node, error) {
 		}
 	}
 
-	// other links go through ipns resolution and are symlinked into the ipfs mountpoint
-	resolved, err := s.Ipfs.Namesys.Resolve(s.Ipfs.Context(), name)
+	// other links go through ipns resolution and are symlinked into the ipfs mountpoint.
+	// the kernel must not cache these entries, so that a Refresh takes effect
+	// right away. repeated lookups are served from the name system's cache.
+	resp.EntryValid = 0
+
+	if s.failed != nil {
+		if _, failed := s.failed.get(name); failed {
+			return nil, fuse.ENOENT
+		}
+	}
+
+	if s.resolveTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.resolveTimeout)
+		defer cancel()
+	}
+
+	resolved, err := s.resolve(ctx, name)
 	if err != nil {
 		log.Warningf("ipns: namesys resolve error: %s", err)
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fuse.EIO
+		}
+		if s.failed != nil && ctx.Err() == nil {
+			// don't cache a lookup the kernel gave up on
+			s.failed.put(name, "")
+		}
 		return nil, fuse.ENOENT
 	}
 
@@ -209,6 +245,42 @@ func (s *Root) Lookup(ctx context.Context, name string) (fs.Node, error) {
 	return nil, errors.New("invalid path from ipns record")
 }
 
+// resolve resolves name, retrying up to resolveRetries times if it fails. The
+// retries stop as soon as ctx is done, so that the resolve timeout bounds all
+// the attempts together, and a cancelled request doesn't wait for them.
+func (s *Root) resolve(ctx context.Context, name string) (path.Path, error) {
+	wait := resolveRetryDelay
+	for i := 0; ; i++ {
+		resolved, err := s.Ipfs.Namesys.Resolve(ctx, name)
+		if err == nil || i == s.resolveRetries || ctx.Err() != nil {
+			return resolved, err
+		}
+
+		log.Debugf("ipns: resolving %s failed, retrying in %s: %s", name, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return "", err
+		}
+		wait *= 2
+	}
+}
+
+// Refresh drops the cached resolution of the given ipns name, both the
+// mount's and the name system's, so that the next access through the mount
+// resolves it again. It returns whether the name was cached.
+func (s *Root) Refresh(name string) bool {
+	name = strings.TrimPrefix(name, "/ipns/")
+	cached := false
+	if s.failed != nil && s.failed.invalidate(name) {
+		cached = true
+	}
+	if inv, ok := s.Ipfs.Namesys.(namesys.Invalidator); ok && inv.Invalidate(name) {
+		cached = true
+	}
+	return cached
+}
+
 func (r *Root) Close() error {
 	for _, mr := range r.Roots {
 		err := mr.root.Close()
@@ -571,7 +643,7 @@ func min(a, b int) int {
 type ipnsRoot interface {
 	fs.Node
 	fs.HandleReadDirAller
-	fs.NodeStringLookuper
+	fs.NodeRequestLookuper
 }
 
 var _ ipnsRoot = (*Root)(nil)
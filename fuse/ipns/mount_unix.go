@@ -4,12 +4,40 @@
 package ipns
 
 import (
+	"time"
+
+	fuse "github.com/ipfs/go-ipfs/Godeps/_workspace/src/bazil.org/fuse"
 	core "github.com/ipfs/go-ipfs/core"
 	mount "github.com/ipfs/go-ipfs/fuse/mount"
 )
 
+// Options tunes an ipns mount. The zero value gives the defaults.
+type Options struct {
+	// ResolveTimeout bounds each resolution of a name looked up through the
+	// mount root, retries included. Zero means no bound.
+	ResolveTimeout time.Duration
+
+	// ResolveRetries is how many more times a resolution that failed is
+	// tried, after a growing delay, before the lookup fails.
+	ResolveRetries int
+
+	// NegativeCacheTTL is how long a name that failed to resolve is answered
+	// with ENOENT before it is resolved again. Zero means
+	// DefaultNegativeCacheTTL, and a negative value disables the caching.
+	NegativeCacheTTL time.Duration
+
+	// VolumeName names the mount, see mount.NameOptions. Empty leaves the
+	// platform's default.
+	VolumeName string
+}
+
 // Mount mounts ipns at a given location, and returns a mount.Mount instance.
 func Mount(ipfs *core.IpfsNode, ipnsmp, ipfsmp string) (mount.Mount, error) {
+	return MountWithOptions(ipfs, ipnsmp, ipfsmp, Options{})
+}
+
+// MountWithOptions is like Mount, tuned by opts.
+func MountWithOptions(ipfs *core.IpfsNode, ipnsmp, ipfsmp string, opts Options) (mount.Mount, error) {
 	cfg, err := ipfs.Repo.Config()
 	if err != nil {
 		return nil, err
@@ -25,6 +53,44 @@ func Mount(ipfs *core.IpfsNode, ipnsmp, ipfsmp string) (mount.Mount, error) {
 	if err != nil {
 		return nil, err
 	}
+	fsys.RootNode.resolveTimeout = opts.ResolveTimeout
+	fsys.RootNode.resolveRetries = opts.ResolveRetries
+	switch {
+	case opts.NegativeCacheTTL < 0:
+		fsys.RootNode.failed = nil
+	case opts.NegativeCacheTTL > 0:
+		fsys.RootNode.failed = newResolveCache(opts.NegativeCacheTTL)
+	}
 
-	return mount.NewMount(ipfs.Process(), fsys, ipnsmp, allow_other)
+	var fuseOpts []fuse.MountOption
+	if opts.VolumeName != "" {
+		fuseOpts = mount.NameOptions(opts.VolumeName, "ipns")
+	}
+	m, err := mount.NewMount(ipfs.Process(), fsys, ipnsmp, allow_other, fuseOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mounted{Mount: m, fsys: fsys}, nil
+}
+
+// Mounted is a live ipns mount. Besides the mount.Mount interface, it gives
+// access to the filesystem's name resolution cache.
+type Mounted struct {
+	mount.Mount
+	fsys *FileSystem
+}
+
+// Refresh drops the cached resolution of an ipns name, so that the next
+// access through the mount resolves it again.
+func (m *Mounted) Refresh(name string) bool {
+	return m.fsys.RootNode.Refresh(name)
+}
+
+// Idle implements mount.IdleTracker for the mount it wraps.
+func (m *Mounted) Idle() time.Duration {
+	if t, ok := m.Mount.(mount.IdleTracker); ok {
+		return t.Idle()
+	}
+	return 0
 }
@@ -7,10 +7,12 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/bazil.org/fuse"
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/bazil.org/fuse/fs"
+	key "github.com/ipfs/go-ipfs/blocks/key"
 
 	goprocess "gx/ipfs/QmQopLATEYMNg7dVqZRNDfeE2S1yKy8zrRh5xnYiuqeZBn/goprocess"
 )
@@ -19,6 +21,10 @@ var ErrNotMounted = errors.New("not mounted")
 
 // mount implements go-ipfs/fuse/mount
 type mount struct {
+	// time of the last request served, in unix nanoseconds. Accessed
+	// atomically, so it comes first, for 64-bit alignment.
+	lastRequest int64
+
 	mpoint   string
 	filesys  fs.FS
 	fuseConn *fuse.Conn
@@ -30,17 +36,15 @@ type mount struct {
 }
 
 // Mount mounts a fuse fs.FS at a given location, and returns a Mount instance.
-// parent is a ContextGroup to bind the mount's ContextGroup to.
-func NewMount(p goprocess.Process, fsys fs.FS, mountpoint string, allow_other bool) (Mount, error) {
-	var conn *fuse.Conn
-	var err error
-
+// parent is a ContextGroup to bind the mount's ContextGroup to. opts are
+// passed on to fuse.
+func NewMount(p goprocess.Process, fsys fs.FS, mountpoint string, allow_other bool, opts ...fuse.MountOption) (Mount, error) {
 	if allow_other {
-		conn, err = fuse.Mount(mountpoint, fuse.AllowOther())
-	} else {
-		conn, err = fuse.Mount(mountpoint)
+		opts = append(opts, fuse.AllowOther())
 	}
 
+	conn, err := fuse.Mount(mountpoint, opts...)
+
 	if err != nil {
 		return nil, err
 	}
@@ -64,13 +68,27 @@ func NewMount(p goprocess.Process, fsys fs.FS, mountpoint string, allow_other bo
 	return m, nil
 }
 
+// NameOptions returns the mount options that name a mount: the volume name
+// shown in Finder on OS X, and the source and type (fuse.<kind>) listed for it
+// on Linux. Platforms that don't support an option ignore it.
+func NameOptions(name, kind string) []fuse.MountOption {
+	return []fuse.MountOption{
+		fuse.VolumeName(name),
+		fuse.FSName(name),
+		fuse.Subtype(kind),
+	}
+}
+
 func (m *mount) mount() error {
 	log.Infof("Mounting %s", m.MountPoint())
 
+	atomic.StoreInt64(&m.lastRequest, time.Now().UnixNano())
+	server := &fs.Server{FS: m.filesys, Debug: m.debug}
+
 	errs := make(chan error, 1)
 	go func() {
 		// fs.Serve blocks until the filesystem is unmounted.
-		err := fs.Serve(m.fuseConn, m.filesys)
+		err := server.Serve(m.fuseConn)
 		log.Debugf("%s is unmounted", m.MountPoint())
 		if err != nil {
 			log.Debugf("fs.Serve returned (%s)", err)
@@ -132,10 +150,30 @@ func (m *mount) unmount() error {
 	return nil
 }
 
+// debug sees every request served, and its response, so it notes the time of
+// the last one for Idle before passing them on to fuse's debug log.
+func (m *mount) debug(msg interface{}) {
+	atomic.StoreInt64(&m.lastRequest, time.Now().UnixNano())
+	fuse.Debug(msg)
+}
+
+// Idle implements IdleTracker.
+func (m *mount) Idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&m.lastRequest)))
+}
+
 func (m *mount) Process() goprocess.Process {
 	return m.proc
 }
 
+// InUse implements InUseTracker, for filesystems that do.
+func (m *mount) InUse() []key.Key {
+	if t, ok := m.filesys.(InUseTracker); ok {
+		return t.InUse()
+	}
+	return nil
+}
+
 func (m *mount) MountPoint() string {
 	return m.mpoint
 }
@@ -145,6 +183,15 @@ func (m *mount) Unmount() error {
 		return ErrNotMounted
 	}
 
+	select {
+	case <-m.proc.Closed():
+		// the process is done, but the mount is still active: the
+		// unmount failed, e.g. because the mountpoint was busy, so try
+		// it again directly
+		return m.unmount()
+	default:
+	}
+
 	// call Process Close(), which calls unmount() exactly once.
 	return m.proc.Close()
 }
@@ -8,6 +8,7 @@ import (
 	"runtime"
 	"time"
 
+	key "github.com/ipfs/go-ipfs/blocks/key"
 	goprocess "gx/ipfs/QmQopLATEYMNg7dVqZRNDfeE2S1yKy8zrRh5xnYiuqeZBn/goprocess"
 	logging "gx/ipfs/Qmazh5oNUVsDZTs2g59rq8aYQqwpss8tcUWQzor5sCCEuH/go-log"
 )
@@ -32,6 +33,79 @@ type Mount interface {
 	Process() goprocess.Process
 }
 
+// InUseTracker is implemented by mounts, and the filesystems they serve,
+// that know which objects are being read through them.
+type InUseTracker interface {
+	// InUse returns the keys of the objects being read, such as open files.
+	InUse() []key.Key
+}
+
+// IdleTracker is implemented by mounts that know when they were last used.
+type IdleTracker interface {
+	// Idle returns how long the mount has served no requests.
+	Idle() time.Duration
+}
+
+// UnmountWhenIdle unmounts m once it has served no requests for timeout, and
+// then calls unmounted. If the unmount fails, e.g. because the mountpoint is
+// busy, it tries again once m has been idle for timeout more. It returns right
+// away, and does nothing if m isn't an IdleTracker. The goroutine watching m
+// ends when m is unmounted otherwise.
+func UnmountWhenIdle(m Mount, timeout time.Duration, unmounted func()) {
+	t, ok := m.(IdleTracker)
+	if !ok {
+		return
+	}
+
+	go func() {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		closed := m.Process().Closed()
+		for {
+			select {
+			case <-closed:
+				if !m.IsActive() {
+					return
+				}
+				// a failed unmount closed the process, but the mount
+				// is still there to be unmounted
+				closed = nil
+				continue
+			case <-timer.C:
+			}
+
+			// any request since the timer was set pushes the unmount back
+			idle := t.Idle()
+			if idle < timeout {
+				timer.Reset(timeout - idle)
+				continue
+			}
+
+			log.Infof("Unmounting %s, idle for %s", m.MountPoint(), idle)
+			if err := m.Unmount(); err != nil {
+				if !m.IsActive() {
+					// unmounted by someone else meanwhile
+					return
+				}
+				log.Warningf("unmounting idle %s, will retry: %s", m.MountPoint(), err)
+				timer.Reset(timeout)
+				continue
+			}
+			unmounted()
+			return
+		}
+	}()
+}
+
+// InUse returns the keys of the objects being read through m, or nil if m
+// doesn't track them. GC keeps these objects even if they are not pinned.
+func InUse(m Mount) []key.Key {
+	if t, ok := m.(InUseTracker); ok && m.IsActive() {
+		return t.InUse()
+	}
+	return nil
+}
+
 // ForceUnmount attempts to forcibly unmount a given mount.
 // It does so by calling diskutil or fusermount directly.
 func ForceUnmount(m Mount) error {
@@ -0,0 +1,60 @@
+package mount
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	goprocess "gx/ipfs/QmQopLATEYMNg7dVqZRNDfeE2S1yKy8zrRh5xnYiuqeZBn/goprocess"
+)
+
+// idleMount is an idle mount whose first unmounts fail, as if busy.
+type idleMount struct {
+	proc goprocess.Process
+
+	lk       sync.Mutex
+	fails    int
+	attempts int
+	active   bool
+}
+
+func (m *idleMount) MountPoint() string         { return "/idle" }
+func (m *idleMount) Process() goprocess.Process { return m.proc }
+func (m *idleMount) Idle() time.Duration        { return time.Hour }
+
+func (m *idleMount) IsActive() bool {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.active
+}
+
+func (m *idleMount) Unmount() error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.attempts++
+	if m.attempts <= m.fails {
+		return errors.New("device or resource busy")
+	}
+	m.active = false
+	return nil
+}
+
+func TestUnmountWhenIdleRetries(t *testing.T) {
+	m := &idleMount{proc: goprocess.WithParent(goprocess.Background()), fails: 2, active: true}
+	defer m.proc.Close()
+
+	unmounted := make(chan struct{})
+	UnmountWhenIdle(m, 10*time.Millisecond, func() { close(unmounted) })
+
+	select {
+	case <-unmounted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("mount not unmounted after its unmount failed")
+	}
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	if m.active || m.attempts != 3 {
+		t.Fatalf("expected to be unmounted on the third attempt, got %d attempts", m.attempts)
+	}
+}
@@ -101,3 +101,29 @@ func TestExternalUnmount(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCheckMountpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fusetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := checkMountpoint(dir); err != nil {
+		t.Fatalf("empty directory rejected: %s", err)
+	}
+
+	if err := checkMountpoint(dir + "/missing"); err == nil {
+		t.Fatal("expected missing mountpoint to be rejected")
+	}
+
+	if err := ioutil.WriteFile(dir+"/file", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkMountpoint(dir); err == nil {
+		t.Fatal("expected non-empty mountpoint to be rejected")
+	}
+	if err := checkMountpoint(dir + "/file"); err == nil {
+		t.Fatal("expected file mountpoint to be rejected")
+	}
+}
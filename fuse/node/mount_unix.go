@@ -6,13 +6,17 @@ package node
 import (
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 	"time"
 
+	cmds "github.com/ipfs/go-ipfs/commands"
 	core "github.com/ipfs/go-ipfs/core"
 	ipns "github.com/ipfs/go-ipfs/fuse/ipns"
 	mount "github.com/ipfs/go-ipfs/fuse/mount"
 	rofs "github.com/ipfs/go-ipfs/fuse/readonly"
+	path "github.com/ipfs/go-ipfs/path"
 	logging "gx/ipfs/Qmazh5oNUVsDZTs2g59rq8aYQqwpss8tcUWQzor5sCCEuH/go-log"
 )
 
@@ -34,7 +38,49 @@ var platformFuseChecks = func(*core.IpfsNode) error {
 	return nil
 }
 
+// Options tunes the ipfs and ipns mounts. The zero value gives the defaults.
+type Options struct {
+	// ResolveTimeout bounds the resolution of names under the ipns mount.
+	ResolveTimeout time.Duration
+
+	// ResolveRetries is how many times the ipns mount retries a failed
+	// resolution, see ipns.Options.
+	ResolveRetries int
+
+	// NegativeCacheTTL is how long the ipns mount remembers a name that
+	// failed to resolve, see ipns.Options.
+	NegativeCacheTTL time.Duration
+
+	// VolumeName, if set, names the mounts <VolumeName>-ipfs and
+	// <VolumeName>-ipns.
+	VolumeName string
+
+	// PinsDir, if set, is where to also mount a listing of the node's
+	// recursive pins, named <VolumeName>-pins.
+	PinsDir string
+
+	// IdleTimeout, if set, unmounts each mount that served no requests for
+	// this long, and clears it from node.Mounts.
+	IdleTimeout time.Duration
+
+	// Aliases are extra names at the root of the ipfs mount, each leading
+	// to the object at its path, see rofs.FileSystem.Aliases.
+	Aliases map[string]path.Path
+
+	// CaseInsensitive makes lookups in the ipfs mount fall back to names
+	// differing only in case, see rofs.FileSystem.CaseInsensitive.
+	CaseInsensitive bool
+}
+
 func Mount(node *core.IpfsNode, fsdir, nsdir string) error {
+	return MountWithOptions(node, fsdir, nsdir, Options{})
+}
+
+// MountWithOptions is like Mount, tuned by opts.
+func MountWithOptions(node *core.IpfsNode, fsdir, nsdir string, opts Options) error {
+	node.Mounts.Lock()
+	defer node.Mounts.Unlock()
+
 	// check if we already have live mounts.
 	// if the user said "Mount", then there must be something wrong.
 	// so, close them and try again.
@@ -44,20 +90,109 @@ func Mount(node *core.IpfsNode, fsdir, nsdir string) error {
 	if node.Mounts.Ipns != nil && node.Mounts.Ipns.IsActive() {
 		node.Mounts.Ipns.Unmount()
 	}
+	if node.Mounts.Pins != nil && node.Mounts.Pins.IsActive() {
+		node.Mounts.Pins.Unmount()
+	}
 
 	if err := platformFuseChecks(node); err != nil {
 		return err
 	}
 
+	dirs := []string{fsdir, nsdir}
+	if opts.PinsDir != "" {
+		dirs = append(dirs, opts.PinsDir)
+	}
+	for _, dir := range dirs {
+		if err := checkMountpoint(dir); err != nil {
+			return err
+		}
+	}
+
 	var err error
-	if err = doMount(node, fsdir, nsdir); err != nil {
+	if err = doMount(node, fsdir, nsdir, opts); err != nil {
+		return err
+	}
+
+	if opts.PinsDir != "" {
+		if err := mountPins(node, opts); err != nil {
+			node.Mounts.Ipfs.Unmount()
+			node.Mounts.Ipns.Unmount()
+			return err
+		}
+	}
+
+	if opts.IdleTimeout > 0 {
+		unmountWhenIdle(node, opts.IdleTimeout)
+	}
+	return nil
+}
+
+// unmountWhenIdle unmounts each of the node's mounts once it has been idle
+// for timeout. A mount that is replaced by a new one meanwhile is unmounted
+// by the replacing MountWithOptions, which ends its watch. It must be called
+// with node.Mounts locked.
+func unmountWhenIdle(node *core.IpfsNode, timeout time.Duration) {
+	for _, mp := range []*mount.Mount{&node.Mounts.Ipfs, &node.Mounts.Ipns, &node.Mounts.Pins} {
+		m := *mp
+		if m == nil {
+			continue
+		}
+		mp := mp
+		mount.UnmountWhenIdle(m, timeout, func() {
+			node.Mounts.Lock()
+			defer node.Mounts.Unlock()
+			if *mp == m {
+				*mp = nil
+			}
+		})
+	}
+}
+
+func mountPins(node *core.IpfsNode, opts Options) error {
+	var pinsOpts rofs.Options
+	if opts.VolumeName != "" {
+		pinsOpts.VolumeName = opts.VolumeName + "-pins"
+	}
+
+	m, err := rofs.MountPins(node, opts.PinsDir, pinsOpts)
+	if err != nil {
+		log.Errorf("error mounting: %s", err)
+		return err
+	}
+	node.Mounts.Pins = m
+	return nil
+}
+
+// checkMountpoint verifies that dir is an existing, empty directory, which
+// FUSE requires, and otherwise returns a client error saying what is wrong.
+func checkMountpoint(dir string) error {
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return cmds.ClientError(fmt.Sprintf("mountpoint %s does not exist; create it first", dir))
+	}
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return cmds.ClientError(fmt.Sprintf("mountpoint %s is not a directory", dir))
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
 		return err
 	}
+	defer f.Close()
 
+	if _, err := f.Readdirnames(1); err != io.EOF {
+		if err != nil {
+			return err
+		}
+		return cmds.ClientError(fmt.Sprintf("mountpoint %s is not empty", dir))
+	}
 	return nil
 }
 
-func doMount(node *core.IpfsNode, fsdir, nsdir string) error {
+func doMount(node *core.IpfsNode, fsdir, nsdir string, opts Options) error {
 	fmtFuseErr := func(err error, mountpoint string) error {
 		s := err.Error()
 		if strings.Contains(s, fuseNoDirectory) {
@@ -72,6 +207,17 @@ func doMount(node *core.IpfsNode, fsdir, nsdir string) error {
 		return err
 	}
 
+	fsOpts := rofs.Options{Aliases: opts.Aliases, CaseInsensitive: opts.CaseInsensitive}
+	nsOpts := ipns.Options{
+		ResolveTimeout:   opts.ResolveTimeout,
+		ResolveRetries:   opts.ResolveRetries,
+		NegativeCacheTTL: opts.NegativeCacheTTL,
+	}
+	if opts.VolumeName != "" {
+		fsOpts.VolumeName = opts.VolumeName + "-ipfs"
+		nsOpts.VolumeName = opts.VolumeName + "-ipns"
+	}
+
 	// this sync stuff is so that both can be mounted simultaneously.
 	var fsmount mount.Mount
 	var nsmount mount.Mount
@@ -81,12 +227,12 @@ func doMount(node *core.IpfsNode, fsdir, nsdir string) error {
 	done := make(chan struct{})
 
 	go func() {
-		fsmount, err1 = rofs.Mount(node, fsdir)
+		fsmount, err1 = rofs.MountWithOptions(node, fsdir, fsOpts)
 		done <- struct{}{}
 	}()
 
 	go func() {
-		nsmount, err2 = ipns.Mount(node, nsdir, fsdir)
+		nsmount, err2 = ipns.MountWithOptions(node, nsdir, fsdir, nsOpts)
 		done <- struct{}{}
 	}()
 
@@ -0,0 +1,54 @@
+// +build linux darwin freebsd
+// +build !nofuse
+
+package readonly
+
+import (
+	"sync"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+)
+
+// openSet counts the open handles of the files of a filesystem, by key, so
+// that GC can keep the files being read.
+type openSet struct {
+	lk   sync.Mutex
+	refs map[key.Key]int
+}
+
+func newOpenSet() *openSet {
+	return &openSet{refs: make(map[key.Key]int)}
+}
+
+func (s *openSet) add(k key.Key) {
+	if s == nil {
+		return
+	}
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.refs[k]++
+}
+
+func (s *openSet) remove(k key.Key) {
+	if s == nil {
+		return
+	}
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.refs[k]--; s.refs[k] <= 0 {
+		delete(s.refs, k)
+	}
+}
+
+func (s *openSet) keys() []key.Key {
+	if s == nil {
+		return nil
+	}
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	keys := make([]key.Key, 0, len(s.refs))
+	for k := range s.refs {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -6,12 +6,14 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path"
 	"sync"
 	"testing"
+	"time"
 
 	fstest "github.com/ipfs/go-ipfs/Godeps/_workspace/src/bazil.org/fuse/fs/fstestutil"
 
@@ -22,6 +24,7 @@ import (
 	importer "github.com/ipfs/go-ipfs/importer"
 	chunk "github.com/ipfs/go-ipfs/importer/chunk"
 	dag "github.com/ipfs/go-ipfs/merkledag"
+	ipfspath "github.com/ipfs/go-ipfs/path"
 	ci "github.com/ipfs/go-ipfs/thirdparty/testutil/ci"
 	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	u "gx/ipfs/QmZNVWh8LLjAavuQ2JXuFmuYH3C11xo988vSgp7UQrTRj1/go-ipfs-util"
@@ -275,3 +278,263 @@ func TestFileSizeReporting(t *testing.T) {
 		t.Fatal("Read incorrect size from stat!")
 	}
 }
+
+// Test reading at random offsets of a deep file, as a media player seeking
+// through it would
+func TestIpfsSeekRead(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	nd, mnt := setupIpfsTest(t, nil)
+	defer mnt.Close()
+
+	// small blocks, so that the file is several levels deep
+	data := make([]byte, 1<<20)
+	u.NewTimeSeededRand().Read(data)
+	fi, err := importer.BuildDagFromReader(nd.DAG, chunk.NewSizeSplitter(bytes.NewReader(data), 512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := fi.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path.Join(mnt.Dir, k.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for i := 0; i < 100; i++ {
+		off := rand.Int63n(int64(len(data)))
+		rbuf := make([]byte, 1+rand.Intn(8192))
+		n, err := f.ReadAt(rbuf, off)
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+
+		read, err := coreunix.Cat(nd.Context(), nd, k.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := read.Seek(off, os.SEEK_SET); err != nil {
+			t.Fatal(err)
+		}
+		expected := make([]byte, len(rbuf))
+		m, err := io.ReadFull(read, expected)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatal(err)
+		}
+		read.Close()
+
+		if !bytes.Equal(rbuf[:n], expected[:m]) {
+			t.Fatalf("incorrect read of %d bytes at offset %d", len(rbuf), off)
+		}
+	}
+}
+
+// Test that the pins filesystem lists recursive pins as they change
+func TestPinsDir(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	maybeSkipFuseTests(t)
+
+	nd, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnt, err := fstest.MountedT(t, NewPinsFileSystem(nd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mnt.Close()
+
+	fi, data := randObj(t, nd, 10000)
+	k, err := fi.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path.Join(mnt.Dir, k.String())); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be absent before it is pinned, got %v", k, err)
+	}
+
+	if err := nd.Pinning.Pin(nd.Context(), fi, true); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := ioutil.ReadDir(mnt.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0].Name() != k.String() {
+		t.Fatalf("expected a single entry %s, got %v", k, names)
+	}
+
+	rbuf, err := ioutil.ReadFile(path.Join(mnt.Dir, k.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rbuf, data) {
+		t.Fatal("Incorrect Read!")
+	}
+}
+
+// Test that aliases are listed at the root and lead to their objects
+func TestAliases(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	maybeSkipFuseTests(t)
+
+	nd, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, data := randObj(t, nd, 10000)
+	k, err := fi.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := uio.NewDirectory(nd.DAG)
+	if err := db.AddChild(nd.Context(), "actual", k); err != nil {
+		t.Fatal(err)
+	}
+	dk, err := nd.DAG.Add(db.GetNode())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := NewFileSystem(nd)
+	fsys.Aliases = map[string]ipfspath.Path{
+		"dataset": ipfspath.FromKey(dk),
+		"file":    ipfspath.Path("/ipfs/" + dk.B58String() + "/actual"),
+	}
+	mnt, err := fstest.MountedT(t, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mnt.Close()
+
+	names, err := ioutil.ReadDir(mnt.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0].Name() != "dataset" || names[1].Name() != "file" {
+		t.Fatalf("expected the entries dataset and file, got %v", names)
+	}
+
+	for _, p := range []string{"dataset/actual", "file", k.B58String()} {
+		rbuf, err := ioutil.ReadFile(path.Join(mnt.Dir, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rbuf, data) {
+			t.Fatalf("incorrect read of %s", p)
+		}
+	}
+}
+
+// Test that case-insensitive lookups fall back to names differing in case
+func TestCaseInsensitive(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	maybeSkipFuseTests(t)
+
+	nd, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := make(map[string][]byte)
+	db := uio.NewDirectory(nd.DAG)
+	for _, name := range []string{"README", "readme", "Other"} {
+		fi, data := randObj(t, nd, 1000)
+		k, err := fi.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AddChild(nd.Context(), name, k); err != nil {
+			t.Fatal(err)
+		}
+		contents[name] = data
+	}
+	dk, err := nd.DAG.Add(db.GetNode())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := NewFileSystem(nd)
+	fsys.CaseInsensitive = true
+	mnt, err := fstest.MountedT(t, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mnt.Close()
+
+	// exact matches win, and of several others the first in byte order
+	expected := map[string]string{
+		"other":  "Other",
+		"readme": "readme",
+		"Readme": "README",
+	}
+	for name, actual := range expected {
+		rbuf, err := ioutil.ReadFile(path.Join(mnt.Dir, dk.B58String(), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rbuf, contents[actual]) {
+			t.Fatalf("expected %s to read %s", name, actual)
+		}
+	}
+	if _, err := ioutil.ReadFile(path.Join(mnt.Dir, dk.B58String(), "missing")); err == nil {
+		t.Fatal("expected a missing name to fail")
+	}
+}
+
+// Test that open files are reported in use until they are closed
+func TestOpenFilesInUse(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	maybeSkipFuseTests(t)
+
+	nd, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := NewFileSystem(nd)
+	mnt, err := fstest.MountedT(t, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mnt.Close()
+
+	fi, _ := randObj(t, nd, 10000)
+	k, err := fi.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path.Join(mnt.Dir, k.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inUse := fsys.InUse()
+	if len(inUse) != 1 || inUse[0] != k {
+		t.Fatalf("expected %s in use, got %v", k, inUse)
+	}
+	f.Close()
+
+	// the release reaches the filesystem asynchronously
+	for i := 0; len(fsys.InUse()) > 0; i++ {
+		if i == 50 {
+			t.Fatalf("expected nothing in use after close, got %v", fsys.InUse())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -4,17 +4,60 @@
 package readonly
 
 import (
+	fuse "github.com/ipfs/go-ipfs/Godeps/_workspace/src/bazil.org/fuse"
 	core "github.com/ipfs/go-ipfs/core"
 	mount "github.com/ipfs/go-ipfs/fuse/mount"
+	path "github.com/ipfs/go-ipfs/path"
 )
 
+// Options tunes an ipfs mount. The zero value gives the defaults.
+type Options struct {
+	// VolumeName names the mount, see mount.NameOptions. Empty leaves the
+	// platform's default.
+	VolumeName string
+
+	// Aliases are extra names at the root of the mount, see
+	// FileSystem.Aliases. MountPins ignores them.
+	Aliases map[string]path.Path
+
+	// CaseInsensitive sets FileSystem.CaseInsensitive. MountPins ignores
+	// it.
+	CaseInsensitive bool
+}
+
 // Mount mounts ipfs at a given location, and returns a mount.Mount instance.
 func Mount(ipfs *core.IpfsNode, mountpoint string) (mount.Mount, error) {
+	return MountWithOptions(ipfs, mountpoint, Options{})
+}
+
+// MountWithOptions is like Mount, tuned by opts.
+func MountWithOptions(ipfs *core.IpfsNode, mountpoint string, opts Options) (mount.Mount, error) {
 	cfg, err := ipfs.Repo.Config()
 	if err != nil {
 		return nil, err
 	}
 	allow_other := cfg.Mounts.FuseAllowOther
 	fsys := NewFileSystem(ipfs)
-	return mount.NewMount(ipfs.Process(), fsys, mountpoint, allow_other)
+	fsys.Aliases = opts.Aliases
+	fsys.CaseInsensitive = opts.CaseInsensitive
+
+	var fuseOpts []fuse.MountOption
+	if opts.VolumeName != "" {
+		fuseOpts = mount.NameOptions(opts.VolumeName, "ipfs")
+	}
+	return mount.NewMount(ipfs.Process(), fsys, mountpoint, allow_other, fuseOpts...)
+}
+
+// MountPins mounts a PinsFileSystem of ipfs at mountpoint.
+func MountPins(ipfs *core.IpfsNode, mountpoint string, opts Options) (mount.Mount, error) {
+	cfg, err := ipfs.Repo.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	var fuseOpts []fuse.MountOption
+	if opts.VolumeName != "" {
+		fuseOpts = mount.NameOptions(opts.VolumeName, "ipfs-pins")
+	}
+	return mount.NewMount(ipfs.Process(), NewPinsFileSystem(ipfs), mountpoint, cfg.Mounts.FuseAllowOther, fuseOpts...)
 }
@@ -0,0 +1,86 @@
+// +build linux darwin freebsd
+// +build !nofuse
+
+package readonly
+
+import (
+	"os"
+
+	fuse "github.com/ipfs/go-ipfs/Godeps/_workspace/src/bazil.org/fuse"
+	fs "github.com/ipfs/go-ipfs/Godeps/_workspace/src/bazil.org/fuse/fs"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	core "github.com/ipfs/go-ipfs/core"
+	"gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+// PinsFileSystem is a read-only filesystem listing the node's recursive pins
+// as directory entries named by their hash, each leading into the pinned
+// object.
+type PinsFileSystem struct {
+	Ipfs *core.IpfsNode
+	open *openSet
+}
+
+// NewPinsFileSystem constructs a pins filesystem for the given node.
+func NewPinsFileSystem(ipfs *core.IpfsNode) *PinsFileSystem {
+	return &PinsFileSystem{Ipfs: ipfs, open: newOpenSet()}
+}
+
+// Root constructs the Root of the filesystem, a PinsRoot object.
+func (f PinsFileSystem) Root() (fs.Node, error) {
+	return &PinsRoot{Ipfs: f.Ipfs, open: f.open}, nil
+}
+
+// InUse returns the keys of the files currently open. See FileSystem.InUse.
+func (f PinsFileSystem) InUse() []key.Key {
+	return f.open.keys()
+}
+
+// PinsRoot is the root of a PinsFileSystem. It reads the pinset on every
+// listing and lookup, so it always reflects the current pins.
+type PinsRoot struct {
+	Ipfs *core.IpfsNode
+	open *openSet
+}
+
+// Attr returns file attributes.
+func (*PinsRoot) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0555
+	a.Uid = uint32(os.Getuid())
+	a.Gid = uint32(os.Getgid())
+	return nil
+}
+
+// Lookup returns the pinned object named name, if it is pinned recursively.
+func (s *PinsRoot) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	log.Debugf("PinsRoot Lookup: '%s'", name)
+	k := key.B58KeyDecode(name)
+	if k == "" {
+		return nil, fuse.ENOENT
+	}
+
+	_, pinned, err := s.Ipfs.Pinning.IsPinnedWithType(k, "recursive")
+	if err != nil || !pinned {
+		return nil, fuse.ENOENT
+	}
+
+	nd, err := s.Ipfs.DAG.Get(ctx, k)
+	if err != nil {
+		return nil, fuse.ENOENT
+	}
+	return &Node{Ipfs: s.Ipfs, Nd: nd, open: s.open}, nil
+}
+
+// ReadDirAll lists the recursively pinned objects.
+func (s *PinsRoot) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	log.Debug("PinsRoot ReadDir")
+	keys := s.Ipfs.Pinning.RecursiveKeys()
+	entries := make([]fuse.Dirent, len(keys))
+	for i, k := range keys {
+		// pinned objects can be files or directories
+		entries[i] = fuse.Dirent{Name: k.B58String(), Type: fuse.DT_Unknown}
+	}
+	return entries, nil
+}
+
+var _ roRoot = (*PinsRoot)(nil)
@@ -7,10 +7,13 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strings"
 	"syscall"
 
 	fuse "github.com/ipfs/go-ipfs/Godeps/_workspace/src/bazil.org/fuse"
 	fs "github.com/ipfs/go-ipfs/Godeps/_workspace/src/bazil.org/fuse/fs"
+	key "github.com/ipfs/go-ipfs/blocks/key"
 	core "github.com/ipfs/go-ipfs/core"
 	mdag "github.com/ipfs/go-ipfs/merkledag"
 	path "github.com/ipfs/go-ipfs/path"
@@ -27,26 +30,50 @@ var log = logging.Logger("fuse/ipfs")
 // FileSystem is the readonly Ipfs Fuse Filesystem.
 type FileSystem struct {
 	Ipfs *core.IpfsNode
+
+	// Aliases are extra names at the root of the filesystem, each leading
+	// to the object at its path. They take precedence over hashes.
+	Aliases map[string]path.Path
+
+	// CaseInsensitive makes a name that matches no entry of a directory,
+	// alias names included, match one that differs only in case, for
+	// software expecting a case-insensitive filesystem. See foldMatch.
+	CaseInsensitive bool
+
+	open *openSet
 }
 
 // NewFileSystem constructs new fs using given core.IpfsNode instance.
 func NewFileSystem(ipfs *core.IpfsNode) *FileSystem {
-	return &FileSystem{Ipfs: ipfs}
+	return &FileSystem{Ipfs: ipfs, open: newOpenSet()}
 }
 
 // Root constructs the Root of the filesystem, a Root object.
 func (f FileSystem) Root() (fs.Node, error) {
-	return &Root{Ipfs: f.Ipfs}, nil
+	return &Root{Ipfs: f.Ipfs, Aliases: f.Aliases, CaseInsensitive: f.CaseInsensitive, open: f.open}, nil
+}
+
+// InUse returns the keys of the files currently open, which GC keeps even if
+// they are not pinned, so that they can be read to the end.
+func (f FileSystem) InUse() []key.Key {
+	return f.open.keys()
 }
 
 // Root is the root object of the filesystem tree.
 type Root struct {
-	Ipfs *core.IpfsNode
+	Ipfs            *core.IpfsNode
+	Aliases         map[string]path.Path
+	CaseInsensitive bool
+	open            *openSet
 }
 
 // Attr returns file attributes.
-func (*Root) Attr(ctx context.Context, a *fuse.Attr) error {
+func (s *Root) Attr(ctx context.Context, a *fuse.Attr) error {
 	a.Mode = os.ModeDir | 0111 // -rw+x
+	if len(s.Aliases) > 0 {
+		// the aliases can be listed
+		a.Mode = os.ModeDir | 0555
+	}
 	return nil
 }
 
@@ -59,19 +86,48 @@ func (s *Root) Lookup(ctx context.Context, name string) (fs.Node, error) {
 		return nil, fuse.ENOENT
 	}
 
-	nd, err := s.Ipfs.Resolver.ResolvePath(ctx, path.Path(name))
+	p := path.Path(name)
+	if alias, ok := s.Aliases[name]; ok {
+		p = alias
+	} else if s.CaseInsensitive && len(s.Aliases) > 0 {
+		names := make([]string, 0, len(s.Aliases))
+		for n := range s.Aliases {
+			names = append(names, n)
+		}
+		if match, ok := foldMatch(name, names); ok {
+			p = s.Aliases[match]
+		}
+	}
+
+	nd, err := s.Ipfs.Resolver.ResolvePath(ctx, p)
 	if err != nil {
 		// todo: make this error more versatile.
 		return nil, fuse.ENOENT
 	}
 
-	return &Node{Ipfs: s.Ipfs, Nd: nd}, nil
+	return &Node{Ipfs: s.Ipfs, Nd: nd, fold: s.CaseInsensitive, open: s.open}, nil
 }
 
-// ReadDirAll reads a particular directory. Disallowed for root.
-func (*Root) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+// ReadDirAll lists the aliases of the root. Without any, it is disallowed,
+// as the objects it holds can't be listed.
+func (s *Root) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	log.Debug("Read Root.")
-	return nil, fuse.EPERM
+	if len(s.Aliases) == 0 {
+		return nil, fuse.EPERM
+	}
+
+	names := make([]string, 0, len(s.Aliases))
+	for name := range s.Aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]fuse.Dirent, len(names))
+	for i, name := range names {
+		// aliased objects can be files or directories
+		entries[i] = fuse.Dirent{Name: name, Type: fuse.DT_Unknown}
+	}
+	return entries, nil
 }
 
 // Node is the core object representing a filesystem tree node.
@@ -80,6 +136,8 @@ type Node struct {
 	Nd     *mdag.Node
 	fd     *uio.DagReader
 	cached *ftpb.Data
+	fold   bool // see FileSystem.CaseInsensitive
+	open   *openSet
 }
 
 func (s *Node) loadData() error {
@@ -128,12 +186,41 @@ func (s *Node) Attr(ctx context.Context, a *fuse.Attr) error {
 func (s *Node) Lookup(ctx context.Context, name string) (fs.Node, error) {
 	log.Debugf("Lookup '%s'", name)
 	nodes, err := s.Ipfs.Resolver.ResolveLinks(ctx, s.Nd, []string{name})
+	if err != nil && s.fold {
+		names := make([]string, len(s.Nd.Links))
+		for i, l := range s.Nd.Links {
+			names[i] = l.Name
+		}
+		if match, ok := foldMatch(name, names); ok {
+			nodes, err = s.Ipfs.Resolver.ResolveLinks(ctx, s.Nd, []string{match})
+		}
+	}
 	if err != nil {
 		// todo: make this error more versatile.
 		return nil, fuse.ENOENT
 	}
 
-	return &Node{Ipfs: s.Ipfs, Nd: nodes[len(nodes)-1]}, nil
+	return &Node{Ipfs: s.Ipfs, Nd: nodes[len(nodes)-1], fold: s.fold, open: s.open}, nil
+}
+
+// foldMatch returns the entry of names equal to name under case folding. If
+// several are, such as "README" and "readme" for "Readme", the first of them
+// in byte order is returned, so that the same one is always picked.
+func foldMatch(name string, names []string) (string, bool) {
+	var matches []string
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			matches = append(matches, n)
+		}
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+	if len(matches) > 1 {
+		sort.Strings(matches)
+		log.Warningf("%q matches %d entries ignoring case, using %q", name, len(matches), matches[0])
+	}
+	return matches[0], true
 }
 
 // ReadDirAll reads the link structure as directory entries
@@ -154,6 +241,40 @@ func (s *Node) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	return nil, fuse.ENOENT
 }
 
+// Open records the file being opened as in use, until it is released.
+func (s *Node) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if k, ok := s.fileKey(); ok {
+		s.open.add(k)
+	}
+	return s, nil
+}
+
+// Release undoes Open.
+func (s *Node) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	if k, ok := s.fileKey(); ok {
+		s.open.remove(k)
+	}
+	return nil
+}
+
+// fileKey returns the key of s, unless s is a directory: a directory being
+// listed doesn't need the objects under it to be kept.
+func (s *Node) fileKey() (key.Key, bool) {
+	if s.cached == nil {
+		if err := s.loadData(); err != nil {
+			return "", false
+		}
+	}
+	if s.cached.GetType() == ftpb.Data_Directory {
+		return "", false
+	}
+	k, err := s.Nd.Key()
+	if err != nil {
+		return "", false
+	}
+	return k, true
+}
+
 func (s *Node) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
 	if s.cached.GetType() != ftpb.Data_Symlink {
 		return "", fuse.Errno(syscall.EINVAL)
@@ -161,7 +282,7 @@ func (s *Node) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string,
 	return string(s.cached.GetData()), nil
 }
 
-func (s *Node) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+func (s *Node) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) (err error) {
 
 	k, err := s.Nd.Key()
 	if err != nil {
@@ -174,12 +295,57 @@ func (s *Node) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadR
 	lm["key"] = func() interface{} { return k.Pretty() }
 	lm["req_offset"] = req.Offset
 	lm["req_size"] = req.Size
-	defer log.EventBegin(ctx, "fuseRead", lm).Done()
+	e := log.EventBegin(ctx, "fuseRead", lm)
+	defer func() {
+		if err != nil {
+			// the reader only gets an errno back, so keep the reason, such
+			// as a block that couldn't be fetched, in the event
+			e.SetError(err)
+		}
+		e.Done()
+	}()
+
+	if s.cached == nil {
+		if err := s.loadData(); err != nil {
+			return fmt.Errorf("readonly: loadData() failed: %s", err)
+		}
+	}
+
+	switch s.cached.GetType() {
+	case ftpb.Data_File, ftpb.Data_Raw:
+	default:
+		return s.readSequential(ctx, req, resp, lm)
+	}
+
+	size := int64(s.cached.GetFilesize())
+	if s.cached.GetType() == ftpb.Data_Raw {
+		size = int64(len(s.cached.GetData()))
+	}
+	if req.Offset >= size {
+		resp.Data = resp.Data[:0]
+		return nil
+	}
+
+	buf := resp.Data[:min(req.Size, int(size-req.Offset))]
+	n, err := readAt(ctx, s.Ipfs.DAG, s.Nd, s.cached, buf, req.Offset)
+	if err != nil {
+		return err
+	}
+	resp.Data = resp.Data[:n]
+	lm["res_offset"] = req.Offset
+	lm["res_size"] = n
+	return nil
+}
 
+// readSequential reads through a DagReader, for the node types readAt can't
+// index into.
+func (s *Node) readSequential(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse, lm lgbl.DeferredMap) error {
 	r, err := uio.NewDagReader(ctx, s.Nd, s.Ipfs.DAG)
 	if err != nil {
 		return err
 	}
+	defer r.Close()
+
 	o, err := r.Seek(req.Offset, os.SEEK_SET)
 	lm["res_offset"] = o
 	if err != nil {
@@ -196,6 +362,53 @@ func (s *Node) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadR
 	return nil // may be non-nil / not succeeded
 }
 
+// readAt fills buf with the file data of nd starting at off. It uses the
+// block sizes recorded in each node to descend straight to the block holding
+// off, so only the blocks on that path and the ones covering buf are fetched,
+// and a seek costs one fetch per level of the tree.
+func readAt(ctx context.Context, ds mdag.DAGService, nd *mdag.Node, pb *ftpb.Data, buf []byte, off int64) (int, error) {
+	var n int
+	data := pb.GetData()
+	if off < int64(len(data)) {
+		n = copy(buf, data[off:])
+		off = 0
+	} else {
+		off -= int64(len(data))
+	}
+
+	sizes := pb.GetBlocksizes()
+	if len(sizes) != len(nd.Links) {
+		return n, fmt.Errorf("readonly: node has %d links but %d block sizes", len(nd.Links), len(sizes))
+	}
+
+	for i, bs := range sizes {
+		if n == len(buf) {
+			break
+		}
+		if off >= int64(bs) {
+			off -= int64(bs)
+			continue
+		}
+
+		child, err := nd.Links[i].GetNode(ctx, ds)
+		if err != nil {
+			return n, err
+		}
+		cpb := new(ftpb.Data)
+		if err := proto.Unmarshal(child.Data, cpb); err != nil {
+			return n, err
+		}
+
+		m, err := readAt(ctx, ds, child, cpb, buf[n:], off)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		off = 0
+	}
+	return n, nil
+}
+
 // to check that out Node implements all the interfaces we want
 type roRoot interface {
 	fs.Node
@@ -208,7 +421,9 @@ var _ roRoot = (*Root)(nil)
 type roNode interface {
 	fs.HandleReadDirAller
 	fs.HandleReader
+	fs.HandleReleaser
 	fs.Node
+	fs.NodeOpener
 	fs.NodeStringLookuper
 	fs.NodeReadlinker
 }
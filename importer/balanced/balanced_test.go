@@ -14,6 +14,7 @@ import (
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
 	pin "github.com/ipfs/go-ipfs/pin"
+	ft "github.com/ipfs/go-ipfs/unixfs"
 	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	u "gx/ipfs/QmZNVWh8LLjAavuQ2JXuFmuYH3C11xo988vSgp7UQrTRj1/go-ipfs-util"
 	"gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
@@ -314,3 +315,60 @@ func TestSeekingConsistency(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestRawLeafMaxSize(t *testing.T) {
+	ds := mdtest.Mock()
+	data := make([]byte, 3*512+100)
+	u.NewTimeSeededRand().Read(data)
+
+	dbp := h.DagBuilderParams{
+		Dagserv:        ds,
+		Maxlinks:       h.DefaultLinksPerBlock,
+		RawLeaves:      true,
+		RawLeafMaxSize: 256,
+	}
+
+	nd, err := BalancedLayout(dbp.New(chunk.NewSizeSplitter(bytes.NewReader(data), 512)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nd.Links) != 4 {
+		t.Fatalf("expected 4 leaves, got %d", len(nd.Links))
+	}
+
+	for i, l := range nd.Links {
+		child, err := l.GetNode(context.Background(), ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fsn, err := ft.FSNodeFromBytes(child.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exp := ft.TFile
+		if i == len(nd.Links)-1 {
+			exp = ft.TRaw
+		}
+		if fsn.Type != exp {
+			t.Fatalf("leaf %d: expected type %s, got %s", i, exp, fsn.Type)
+		}
+	}
+
+	rs, err := uio.NewDagReader(context.Background(), nd, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadAll(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = arrComp(out, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,6 +3,7 @@ package helpers
 import (
 	"github.com/ipfs/go-ipfs/importer/chunk"
 	dag "github.com/ipfs/go-ipfs/merkledag"
+	ft "github.com/ipfs/go-ipfs/unixfs"
 )
 
 // DagBuilderHelper wraps together a bunch of objects needed to
@@ -14,6 +15,13 @@ type DagBuilderHelper struct {
 	nextData []byte // the next item to return.
 	maxlinks int
 	batch    *dag.Batch
+
+	rawLeaves  bool
+	rawLeafMax int
+	fileLeaves bool
+
+	leaves int64 // data blocks filled so far
+	depth  int   // depth of the deepest node built so far
 }
 
 type DagBuilderParams struct {
@@ -22,6 +30,20 @@ type DagBuilderParams struct {
 
 	// DAGService to write blocks to (required)
 	Dagserv dag.DAGService
+
+	// RawLeaves stores leaf data as raw unixfs blocks
+	RawLeaves bool
+
+	// RawLeafMaxSize, if non-zero, restricts RawLeaves to leaves of at most
+	// this many bytes. Larger leaves are stored as the layout stores them
+	// without RawLeaves: unixfs file nodes with the balanced layout, raw
+	// blocks with the trickle layout.
+	RawLeafMaxSize int
+
+	// FileLeaves stores every leaf as a unixfs file node, including the
+	// direct blocks of a trickle dag, which are raw otherwise. It is
+	// ignored with RawLeaves.
+	FileLeaves bool
 }
 
 // Generate a new DagBuilderHelper from the given params, which data source comes
@@ -32,6 +54,10 @@ func (dbp *DagBuilderParams) New(spl chunk.Splitter) *DagBuilderHelper {
 		spl:      spl,
 		maxlinks: dbp.Maxlinks,
 		batch:    dbp.Dagserv.Batch(),
+
+		rawLeaves:  dbp.RawLeaves,
+		rawLeafMax: dbp.RawLeafMaxSize,
+		fileLeaves: dbp.FileLeaves,
 	}
 }
 
@@ -102,7 +128,16 @@ func (db *DagBuilderHelper) FillNodeWithData(node *UnixfsNode) error {
 		return ErrSizeLimitExceeded
 	}
 
+	// leaves over rawLeafMax keep the type the layout gave them, which is
+	// already raw for the direct blocks of a trickle dag
+	if db.rawLeaves && (db.rawLeafMax == 0 || len(data) <= db.rawLeafMax) {
+		node.ufmt.Type = ft.TRaw
+	} else if db.fileLeaves && !db.rawLeaves {
+		node.ufmt.Type = ft.TFile
+	}
+
 	node.SetData(data)
+	db.leaves++
 	return nil
 }
 
@@ -120,6 +155,12 @@ func (db *DagBuilderHelper) Add(node *UnixfsNode) (*dag.Node, error) {
 	return dn, nil
 }
 
+// Shape returns the number of data blocks of the DAG built so far, and its
+// depth: zero for a single block, one for a root linking to data blocks, etc.
+func (db *DagBuilderHelper) Shape() (leaves int64, depth int) {
+	return db.leaves, db.depth
+}
+
 func (db *DagBuilderHelper) Maxlinks() int {
 	return db.maxlinks
 }
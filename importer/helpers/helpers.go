@@ -37,8 +37,9 @@ var ErrSizeLimitExceeded = fmt.Errorf("object size limit exceeded")
 // UnixfsNode is a struct created to aid in the generation
 // of unixfs DAG trees
 type UnixfsNode struct {
-	node *dag.Node
-	ufmt *ft.FSNode
+	node  *dag.Node
+	ufmt  *ft.FSNode
+	depth int // levels of nodes below this one
 }
 
 // NewUnixfsNode creates a new Unixfs node to represent a file
@@ -88,6 +89,12 @@ func (n *UnixfsNode) GetChild(ctx context.Context, i int, ds dag.DAGService) (*U
 // pin it locally so it doesnt get lost
 func (n *UnixfsNode) AddChild(child *UnixfsNode, db *DagBuilderHelper) error {
 	n.ufmt.AddBlockSize(child.ufmt.FileSize())
+	if child.depth+1 > n.depth {
+		n.depth = child.depth + 1
+		if n.depth > db.depth {
+			db.depth = n.depth
+		}
+	}
 
 	childnode, err := child.GetDagNode()
 	if err != nil {
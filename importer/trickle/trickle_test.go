@@ -588,3 +588,41 @@ func printDag(nd *merkledag.Node, ds merkledag.DAGService, indent int) {
 	}
 	fmt.Println("}")
 }
+
+func TestTrickleRawLeaves(t *testing.T) {
+	ds := mdtest.Mock()
+	should := make([]byte, 64*512+100)
+	u.NewTimeSeededRand().Read(should)
+
+	dbp := h.DagBuilderParams{
+		Dagserv:        ds,
+		Maxlinks:       4,
+		RawLeaves:      true,
+		RawLeafMaxSize: 256,
+	}
+
+	nd, err := TrickleLayout(dbp.New(chunk.NewSizeSplitter(bytes.NewReader(should), 512)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = VerifyTrickleDagStructure(nd, ds, dbp.Maxlinks, layerRepeat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := uio.NewDagReader(context.Background(), nd, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = arrComp(out, should)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -233,6 +233,12 @@ func VerifyTrickleDagStructure(nd *dag.Node, ds dag.DAGService, direct int, laye
 	return verifyTDagRec(nd, -1, direct, layerRepeat, ds)
 }
 
+// VerifyTrickleDag checks that nd is a trickle dag as this package builds it
+// with the given maxlinks, such as one TrickleAppend can extend.
+func VerifyTrickleDag(nd *dag.Node, ds dag.DAGService, maxlinks int) error {
+	return VerifyTrickleDagStructure(nd, ds, maxlinks, layerRepeat)
+}
+
 // Recursive call for verifying the structure of a trickledag
 func verifyTDagRec(nd *dag.Node, depth, direct, layerRepeat int, ds dag.DAGService) error {
 	if depth == 0 {
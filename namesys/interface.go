@@ -100,6 +100,15 @@ type Resolver interface {
 	ResolveN(ctx context.Context, name string, depth int) (value path.Path, err error)
 }
 
+// Invalidator is implemented by resolvers that cache resolutions.
+type Invalidator interface {
+
+	// Invalidate drops the cached resolution of name, if any, so that
+	// the next resolution of it looks it up again. It returns whether
+	// name was cached.
+	Invalidate(name string) bool
+}
+
 // Publisher is an object capable of publishing particular names.
 type Publisher interface {
 
@@ -85,6 +85,18 @@ func (ns *mpns) resolveOnce(ctx context.Context, name string) (path.Path, error)
 	return "", ErrResolveFailed
 }
 
+// Invalidate implements Invalidator.
+func (ns *mpns) Invalidate(name string) bool {
+	name = strings.TrimPrefix(name, "/ipns/")
+	cached := false
+	for _, resolver := range ns.resolvers {
+		if inv, ok := resolver.(Invalidator); ok && inv.Invalidate(name) {
+			cached = true
+		}
+	}
+	return cached
+}
+
 // Publish implements Publisher
 func (ns *mpns) Publish(ctx context.Context, name ci.PrivKey, value path.Path) error {
 	return ns.publishers["/ipns/"].Publish(ctx, name, value)
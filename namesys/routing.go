@@ -78,6 +78,17 @@ func (r *routingResolver) cacheSet(name string, val path.Path, rec *pb.IpnsEntry
 	})
 }
 
+// Invalidate implements Invalidator.
+func (r *routingResolver) Invalidate(name string) bool {
+	if r.cache == nil {
+		return false
+	}
+
+	_, ok := r.cache.Get(name)
+	r.cache.Remove(name)
+	return ok
+}
+
 type cacheEntry struct {
 	val path.Path
 	eol time.Time
@@ -1,12 +1,19 @@
 package gc
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
 	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
 	key "github.com/ipfs/go-ipfs/blocks/key"
 	bserv "github.com/ipfs/go-ipfs/blockservice"
 	offline "github.com/ipfs/go-ipfs/exchange/offline"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	pin "github.com/ipfs/go-ipfs/pin"
+	iolimit "github.com/ipfs/go-ipfs/thirdparty/iolimit"
 
 	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
 	logging "gx/ipfs/Qmazh5oNUVsDZTs2g59rq8aYQqwpss8tcUWQzor5sCCEuH/go-log"
@@ -20,46 +27,298 @@ var log = logging.Logger("gc")
 // - all directly pinned blocks
 // - all blocks utilized internally by the pinner
 //
+// - the blocks reachable from bestEffortRoots that are in the blockstore
+//
 // The routine then iterates over every block in the blockstore and
 // deletes any block that is not found in the marked set.
-func GC(ctx context.Context, bs bstore.GCBlockstore, pn pin.Pinner) (<-chan key.Key, error) {
+//
+// The blockstore's GC lock is held from marking until the sweep finishes, so
+// GC never runs while an add holds the pin lock between writing its blocks
+// and pinning them.
+//
+// bestEffortRoots name objects that are in use without being pinned, such as
+// the files open under a mount. Unlike pinned objects, they need not be
+// complete: the blocks under them that are missing are skipped.
+func GC(ctx context.Context, bs bstore.GCBlockstore, pn pin.Pinner, bestEffortRoots []key.Key) (<-chan key.Key, error) {
+	results, err := sweep(ctx, bs, pn, bestEffortRoots, false)
+	if err != nil {
+		return nil, err
+	}
+	return removedKeys(ctx, results), nil
+}
+
+// removedKeys forwards the keys of the removed blocks in results.
+func removedKeys(ctx context.Context, results <-chan Result) <-chan key.Key {
+	output := make(chan key.Key)
+	go func() {
+		defer close(output)
+		for r := range results {
+			if r.Error != nil {
+				log.Debugf("Error sweeping blockstore: %s", r.Error)
+				continue
+			}
+			if r.Key == "" {
+				continue
+			}
+			select {
+			case output <- r.Key:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return output
+}
+
+// ErrReleased is returned when sweeping a Marked set that was already swept
+// or released.
+var ErrReleased = errors.New("gc: marked set was already swept or released")
+
+// ErrAgeUnsupported is returned when sweeping with Marked.OlderThan set in a
+// blockstore that can't tell when its blocks were stored.
+var ErrAgeUnsupported = errors.New("gc: the blockstore can't tell the age of its blocks, so none can be spared for being recent")
+
+// Marked is the set of blocks a GC keeps, as computed by Mark. It holds the
+// blockstore's GC lock until it is swept or released, so that nothing is
+// added or pinned between marking and sweeping.
+type Marked struct {
+	Keys key.KeySet
+
+	// SparePrefixes lists prefixes of base58 keys that Sweep leaves alone,
+	// marked or not, such as objects to keep through one GC run without
+	// pinning them.
+	SparePrefixes []string
+
+	// DeleteRate, if non-zero, is the most blocks Sweep removes per
+	// second, so that a GC doesn't saturate a slow datastore. The GC lock
+	// is held for as long as the sweep takes. Rates above a billion don't
+	// limit anything.
+	DeleteRate int
+
+	// IOLimit, if set, is acquired for each block Sweep removes, so that
+	// the GC shares a bound on datastore operations with other users.
+	IOLimit *iolimit.Limiter
+
+	// OlderThan, if non-zero, makes Sweep keep the blocks stored more
+	// recently than this long ago, as recent unpinned blocks may be the
+	// scratch data of an ongoing job. Sweep fails with ErrAgeUnsupported if
+	// the blockstore is not a bstore.Ager.
+	OlderThan time.Duration
+
+	// ShouldRemove, if set, is asked about every block Sweep would remove,
+	// and the block is kept if it returns false. It is never asked about a
+	// block Sweep keeps anyway. It runs on the sweep, with the GC lock held,
+	// so it must be quick and must not add or pin anything.
+	ShouldRemove func(key.Key) bool
+
+	lk       sync.Mutex
+	unlocker bstore.Unlocker
+}
+
+// Mark takes the GC lock of bs and computes the set of blocks reachable from
+// the pins of pn and from bestEffortRoots, the first phase of GC. The caller
+// must either Sweep the set or Release it.
+func Mark(ctx context.Context, bs bstore.GCBlockstore, pn pin.Pinner, bestEffortRoots []key.Key) (*Marked, error) {
 	unlocker := bs.GCLock()
 
 	bsrv := bserv.New(bs, offline.Exchange(bs))
 	ds := dag.NewDAGService(bsrv)
 
 	gcs, err := ColoredSet(ctx, pn, ds)
+	if err == nil {
+		err = bestEffortDescendants(ctx, bs, ds, gcs, bestEffortRoots)
+	}
 	if err != nil {
+		unlocker.Unlock()
 		return nil, err
 	}
+	return &Marked{Keys: gcs, unlocker: unlocker}, nil
+}
+
+// spared reports whether k matches one of m's SparePrefixes.
+func (m *Marked) spared(k key.Key) bool {
+	if len(m.SparePrefixes) == 0 {
+		return false
+	}
+	s := k.B58String()
+	for _, p := range m.SparePrefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// take hands over the GC lock held by m, nil if m was swept or released.
+func (m *Marked) take() bstore.Unlocker {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	u := m.unlocker
+	m.unlocker = nil
+	return u
+}
+
+// Release releases the GC lock held by m without sweeping. It does nothing if
+// m was already swept or released.
+func (m *Marked) Release() {
+	if u := m.take(); u != nil {
+		u.Unlock()
+	}
+}
+
+// Sweep removes every block of bs not in m, the second phase of GC, and
+// releases m's GC lock when done. A set can only be swept once.
+func Sweep(ctx context.Context, bs bstore.GCBlockstore, m *Marked) (<-chan key.Key, error) {
+	results, err := sweepMarked(ctx, bs, m, false)
+	if err != nil {
+		return nil, err
+	}
+	return removedKeys(ctx, results), nil
+}
+
+// SweepWithProgress is like Sweep, but reports progress like GCWithProgress.
+func SweepWithProgress(ctx context.Context, bs bstore.GCBlockstore, m *Marked) (<-chan Result, error) {
+	return sweepMarked(ctx, bs, m, true)
+}
+
+// scanReportInterval is the number of blocks scanned between the progress
+// Results of GCWithProgress that don't carry a removal.
+const scanReportInterval = 1024
+
+// Result is a progress event of GCWithProgress. Key is set, along with the
+// block's Size, when a block was removed. Scanned is the number of blocks
+// examined so far. Error is set on the last Result of a sweep that failed.
+type Result struct {
+	Key     key.Key
+	Size    int64
+	Scanned int64
+	Error   error
+}
+
+// GCWithProgress is like GC, but also reports the size of every removed block
+// and, periodically, how many blocks have been scanned. A final Result with the
+// total scan count is sent when the sweep completes.
+func GCWithProgress(ctx context.Context, bs bstore.GCBlockstore, pn pin.Pinner, bestEffortRoots []key.Key) (<-chan Result, error) {
+	return sweep(ctx, bs, pn, bestEffortRoots, true)
+}
+
+func sweep(ctx context.Context, bs bstore.GCBlockstore, pn pin.Pinner, bestEffortRoots []key.Key, progress bool) (<-chan Result, error) {
+	m, err := Mark(ctx, bs, pn, bestEffortRoots)
+	if err != nil {
+		return nil, err
+	}
+	return sweepMarked(ctx, bs, m, progress)
+}
+
+func sweepMarked(ctx context.Context, bs bstore.GCBlockstore, m *Marked, progress bool) (<-chan Result, error) {
+	unlocker := m.take()
+	if unlocker == nil {
+		return nil, ErrReleased
+	}
+	gcs := m.Keys
+
+	var ager bstore.Ager
+	if m.OlderThan > 0 {
+		var ok bool
+		if ager, ok = bs.(bstore.Ager); !ok {
+			unlocker.Unlock()
+			return nil, ErrAgeUnsupported
+		}
+	}
 
 	keychan, err := bs.AllKeysChan(ctx)
 	if err != nil {
+		unlocker.Unlock()
 		return nil, err
 	}
 
-	output := make(chan key.Key)
+	output := make(chan Result)
+	send := func(r Result) bool {
+		select {
+		case output <- r:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
 	go func() {
 		defer close(output)
 		defer unlocker.Unlock()
+
+		var limit <-chan time.Time
+		// beyond a block per nanosecond, the rate is as good as unlimited
+		if m.DeleteRate > 0 && time.Duration(m.DeleteRate) <= time.Second {
+			ticker := time.NewTicker(time.Second / time.Duration(m.DeleteRate))
+			defer ticker.Stop()
+			limit = ticker.C
+		}
+
+		var scanned int64
 		for {
 			select {
 			case k, ok := <-keychan:
 				if !ok {
+					if progress {
+						send(Result{Scanned: scanned})
+					}
 					return
 				}
-				if !gcs.Has(k) {
-					err := bs.DeleteBlock(k)
+				scanned++
+				keep := gcs.Has(k) || m.spared(k)
+				if !keep && ager != nil {
+					stored, err := ager.StoredAt(k)
+					if err == bstore.ErrNotFound {
+						// removed since we listed it
+						continue
+					}
+					if err != nil {
+						send(Result{Scanned: scanned, Error: fmt.Errorf("reading the age of block %s: %s", k, err)})
+						return
+					}
+					keep = time.Since(stored) < m.OlderThan
+				}
+				if !keep && m.ShouldRemove != nil {
+					keep = !m.ShouldRemove(k)
+				}
+				if keep {
+					if progress && scanned%scanReportInterval == 0 && !send(Result{Scanned: scanned}) {
+						return
+					}
+					continue
+				}
+
+				var size int64
+				if progress {
+					blk, err := bs.Get(k)
+					if err == bstore.ErrNotFound {
+						continue
+					}
 					if err != nil {
-						log.Debugf("Error removing key from blockstore: %s", err)
+						send(Result{Scanned: scanned, Error: fmt.Errorf("reading block %s for removal: %s", k, err)})
 						return
 					}
+					size = int64(len(blk.Data))
+				}
+				if limit != nil {
 					select {
-					case output <- k:
+					case <-limit:
 					case <-ctx.Done():
 						return
 					}
 				}
+				if err := m.IOLimit.Acquire(ctx); err != nil {
+					return
+				}
+				err := bs.DeleteBlock(k)
+				m.IOLimit.Release()
+				if err != nil {
+					send(Result{Scanned: scanned, Error: fmt.Errorf("removing block %s: %s", k, err)})
+					return
+				}
+				if !send(Result{Key: k, Size: size, Scanned: scanned}) {
+					return
+				}
 			case <-ctx.Done():
 				return
 			}
@@ -69,6 +328,135 @@ func GC(ctx context.Context, bs bstore.GCBlockstore, pn pin.Pinner) (<-chan key.
 	return output, nil
 }
 
+// Estimate runs the marking phase of GC and reports how many blocks, and how
+// many bytes, a sweep would remove, without removing anything. It doesn't take
+// the GC lock, so it never blocks adds; blocks of an add that hasn't pinned
+// its result yet are counted as reclaimable.
+func Estimate(ctx context.Context, bs bstore.GCBlockstore, pn pin.Pinner, bestEffortRoots []key.Key) (count int64, size int64, err error) {
+	bsrv := bserv.New(bs, offline.Exchange(bs))
+	ds := dag.NewDAGService(bsrv)
+
+	gcs, err := ColoredSet(ctx, pn, ds)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := bestEffortDescendants(ctx, bs, ds, gcs, bestEffortRoots); err != nil {
+		return 0, 0, err
+	}
+
+	keychan, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for {
+		select {
+		case k, ok := <-keychan:
+			if !ok {
+				// the key listing also stops on cancellation
+				if err := ctx.Err(); err != nil {
+					return 0, 0, err
+				}
+				return count, size, nil
+			}
+			if gcs.Has(k) {
+				continue
+			}
+			blk, err := bs.Get(k)
+			if err == bstore.ErrNotFound {
+				// removed since we listed it
+				continue
+			}
+			if err != nil {
+				return 0, 0, err
+			}
+			count++
+			size += int64(len(blk.Data))
+		case <-ctx.Done():
+			return 0, 0, ctx.Err()
+		}
+	}
+}
+
+// Explain runs the marking phase of GC and reports whether it marks target,
+// and so whether a sweep would keep it. If it does, the path through which it
+// is reached is returned too: the keys from a pin, or from one of
+// bestEffortRoots, down to target. Like Estimate, it doesn't take the GC
+// lock.
+func Explain(ctx context.Context, bs bstore.GCBlockstore, pn pin.Pinner, bestEffortRoots []key.Key, target key.Key) (bool, []key.Key, error) {
+	bsrv := bserv.New(bs, offline.Exchange(bs))
+	ds := dag.NewDAGService(bsrv)
+
+	gcs, err := ColoredSet(ctx, pn, ds)
+	if err != nil {
+		return false, nil, err
+	}
+	if err := bestEffortDescendants(ctx, bs, ds, gcs, bestEffortRoots); err != nil {
+		return false, nil, err
+	}
+	if !gcs.Has(target) {
+		return false, nil, nil
+	}
+
+	// search from the roots in the order ColoredSet marks from them
+	seen := key.NewKeySet()
+	var walk func(k key.Key) ([]key.Key, error)
+	walk = func(k key.Key) ([]key.Key, error) {
+		if k == target {
+			return []key.Key{k}, nil
+		}
+		if seen.Has(k) {
+			return nil, nil
+		}
+		seen.Add(k)
+
+		// best-effort roots and their descendants may be missing
+		has, err := bs.Has(k)
+		if err != nil || !has {
+			return nil, err
+		}
+		nd, err := ds.Get(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range nd.Links {
+			p, err := walk(key.Key(l.Hash))
+			if err != nil {
+				return nil, err
+			}
+			if p != nil {
+				return append([]key.Key{k}, p...), nil
+			}
+		}
+		return nil, nil
+	}
+
+	walkAll := func(roots []key.Key) ([]key.Key, error) {
+		for _, k := range roots {
+			if p, err := walk(k); p != nil || err != nil {
+				return p, err
+			}
+		}
+		return nil, nil
+	}
+
+	if p, err := walkAll(pn.RecursiveKeys()); p != nil || err != nil {
+		return err == nil, p, err
+	}
+	// direct pins keep nothing below them
+	for _, k := range pn.DirectKeys() {
+		if k == target {
+			return true, []key.Key{k}, nil
+		}
+	}
+	for _, roots := range [][]key.Key{pn.InternalPins(), bestEffortRoots} {
+		if p, err := walkAll(roots); p != nil || err != nil {
+			return err == nil, p, err
+		}
+	}
+	return false, nil, fmt.Errorf("%s was marked, but no path to it was found", target)
+}
+
 func Descendants(ctx context.Context, ds dag.DAGService, set key.KeySet, roots []key.Key) error {
 	for _, k := range roots {
 		set.Add(k)
@@ -87,6 +475,118 @@ func Descendants(ctx context.Context, ds dag.DAGService, set key.KeySet, roots [
 	return nil
 }
 
+// bestEffortDescendants adds the roots, and their descendants, to set,
+// skipping those that are not in bs.
+func bestEffortDescendants(ctx context.Context, bs bstore.Blockstore, ds dag.DAGService, set key.KeySet, roots []key.Key) error {
+	for _, k := range roots {
+		if set.Has(k) {
+			continue
+		}
+
+		has, err := bs.Has(k)
+		if err != nil {
+			return err
+		}
+		if !has {
+			continue
+		}
+		set.Add(k)
+
+		nd, err := ds.Get(ctx, k)
+		if err != nil {
+			return err
+		}
+
+		children := make([]key.Key, len(nd.Links))
+		for i, l := range nd.Links {
+			children[i] = key.Key(l.Hash)
+		}
+		if err := bestEffortDescendants(ctx, bs, ds, set, children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MissingPinsError is returned by VerifyPins, listing the pinned blocks that
+// are not in the blockstore.
+type MissingPinsError struct {
+	Keys []key.Key
+}
+
+func (e *MissingPinsError) Error() string {
+	const shown = 10
+	names := make([]string, 0, shown)
+	for i, k := range e.Keys {
+		if i == shown {
+			names = append(names, fmt.Sprintf("and %d more", len(e.Keys)-shown))
+			break
+		}
+		names = append(names, k.B58String())
+	}
+	return fmt.Sprintf("gc: %d pinned blocks are missing: %s", len(e.Keys), strings.Join(names, ", "))
+}
+
+// VerifyPins checks that every block the pins of pn refer to, directly or as
+// the descendant of a recursive pin, is in bs, and returns a MissingPinsError
+// listing those that are not. Unlike Mark, which fails on the first missing
+// block, it walks all of the pins, to report the whole damage at once.
+func VerifyPins(ctx context.Context, bs bstore.Blockstore, pn pin.Pinner) error {
+	ds := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	seen := key.NewKeySet()
+	var missing []key.Key
+
+	var walk func(k key.Key, recursive bool) error
+	walk = func(k key.Key, recursive bool) error {
+		if seen.Has(k) {
+			return nil
+		}
+		seen.Add(k)
+
+		has, err := bs.Has(k)
+		if err != nil {
+			return err
+		}
+		if !has {
+			missing = append(missing, k)
+			return nil
+		}
+		if !recursive {
+			return nil
+		}
+
+		nd, err := ds.Get(ctx, k)
+		if err != nil {
+			return err
+		}
+		for _, l := range nd.Links {
+			if err := walk(key.Key(l.Hash), true); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, roots := range [][]key.Key{pn.RecursiveKeys(), pn.InternalPins()} {
+		for _, k := range roots {
+			if err := walk(k, true); err != nil {
+				return err
+			}
+		}
+	}
+	// last, as the walk doesn't descend into blocks it has already seen
+	for _, k := range pn.DirectKeys() {
+		if err := walk(k, false); err != nil {
+			return err
+		}
+	}
+
+	if len(missing) > 0 {
+		return &MissingPinsError{Keys: missing}
+	}
+	return nil
+}
+
 func ColoredSet(ctx context.Context, pn pin.Pinner, ds dag.DAGService) (key.KeySet, error) {
 	// KeySet currently implemented in memory, in the future, may be bloom filter or
 	// disk backed to conserve memory.
@@ -0,0 +1,66 @@
+package gc
+
+import (
+	"sync"
+	"time"
+
+	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+// Lock lets a single GC run at a time. Blocks the first GC is about to remove
+// would be marked again by a second one, for nothing. Unlike the blockstore's
+// GC lock, it is held for the whole GC, and doesn't keep adds or pins from
+// going on meanwhile.
+type Lock struct {
+	sem     chan struct{} // holds a token while a GC runs
+	mu      sync.Mutex
+	started time.Time
+}
+
+// NewLock returns a Lock no GC holds.
+func NewLock() *Lock {
+	return &Lock{sem: make(chan struct{}, 1)}
+}
+
+// Acquire takes the lock, waiting for the GC holding it to release it. It
+// fails with ctx's error if ctx is done first.
+func (l *Lock) Acquire(ctx context.Context) error {
+	select {
+	case l.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	l.setStarted(time.Now())
+	return nil
+}
+
+// TryAcquire takes the lock unless a GC holds it, and returns whether it did.
+func (l *Lock) TryAcquire() bool {
+	select {
+	case l.sem <- struct{}{}:
+	default:
+		return false
+	}
+	l.setStarted(time.Now())
+	return true
+}
+
+// Release releases the lock, which must be held.
+func (l *Lock) Release() {
+	l.setStarted(time.Time{})
+	<-l.sem
+}
+
+// Started returns when the GC holding the lock took it, or the zero time if
+// no GC does.
+func (l *Lock) Started() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.started
+}
+
+func (l *Lock) setStarted(t time.Time) {
+	l.mu.Lock()
+	l.started = t
+	l.mu.Unlock()
+}
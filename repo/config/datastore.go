@@ -14,6 +14,9 @@ type Datastore struct {
 	StorageMax         string // in B, kB, kiB, MB, ...
 	StorageGCWatermark int64  // in percentage to multiply on StorageMax
 	GCPeriod           string // in ns, us, ms, s, m, h
+	GCMaxDeleteRate    int    // blocks GC removes per second, unlimited if 0
+	GCVerifyPins       bool   // fail GC if any pinned block is missing
+	MaxIOConcurrency   int    // block writes of adds and deletes of GC at once, unlimited if 0
 
 	Params *json.RawMessage
 	NoSync bool
@@ -0,0 +1,30 @@
+package fsrepo
+
+import (
+	"os"
+	"time"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/ipfs/go-datastore/flatfs"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+)
+
+// blockAger tells when the blocks of a flatfs datastore were stored, from the
+// modification times of their files. A block that is put again while stored
+// keeps the time it was first stored at.
+type blockAger struct {
+	fs *flatfs.Datastore
+}
+
+// StoredAt returns when the block k was stored. The blockstore's keys reach
+// the flatfs datastore mounted at /blocks as the blocks' own DsKeys.
+func (a *blockAger) StoredAt(k key.Key) (time.Time, error) {
+	fi, err := os.Stat(a.fs.Path(k.DsKey()))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return time.Time{}, bstore.ErrNotFound
+		}
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
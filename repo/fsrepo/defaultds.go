@@ -44,6 +44,7 @@ func openDefaultDatastore(r *FSRepo) (repo.Datastore, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unable to open flatfs datastore: %v", err)
 	}
+	r.ager = &blockAger{fs: blocksDS}
 
 	// Add our PeerID to metrics paths to keep them unique
 	//
@@ -13,6 +13,7 @@ import (
 
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/ipfs/go-datastore/measure"
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/mitchellh/go-homedir"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
 	repo "github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/repo/common"
 	config "github.com/ipfs/go-ipfs/repo/config"
@@ -87,9 +88,12 @@ type FSRepo struct {
 	lockfile io.Closer
 	config   *config.Config
 	ds       repo.Datastore
+	// ager tells the age of the blocks in a flatfs datastore
+	ager *blockAger
 }
 
 var _ repo.Repo = (*FSRepo)(nil)
+var _ repo.AgingRepo = (*FSRepo)(nil)
 
 // Open the FSRepo at path. Returns an error if the repo is not
 // initialized.
@@ -554,6 +558,15 @@ func (r *FSRepo) Datastore() repo.Datastore {
 	return d
 }
 
+// BlockAger returns what tells when the repo's blocks were stored, or nil if
+// its datastore isn't the default flatfs one.
+func (r *FSRepo) BlockAger() bstore.Ager {
+	if r.ager == nil {
+		return nil
+	}
+	return r.ager
+}
+
 // GetStorageUsage computes the storage space taken by the repo in bytes
 func (r *FSRepo) GetStorageUsage() (uint64, error) {
 	pth, err := config.PathRoot()
@@ -3,9 +3,13 @@ package fsrepo
 import (
 	"bytes"
 	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
 	datastore "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/ipfs/go-datastore"
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
 	"github.com/ipfs/go-ipfs/repo/config"
 	"github.com/ipfs/go-ipfs/thirdparty/assert"
 )
@@ -116,3 +120,36 @@ func TestOpenMoreThanOnceInSameProcess(t *testing.T) {
 	assert.Nil(r1.Close(), t)
 	assert.Nil(r2.Close(), t)
 }
+
+func TestBlockAger(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("ager", t)
+	assert.Nil(Init(path, &config.Config{}), t, "should initialize successfully")
+	r, err := Open(path)
+	assert.Nil(err, t, "should open successfully")
+	defer r.Close()
+
+	ager := r.BlockAger()
+	assert.True(ager != nil, t, "the default datastore should tell the age of its blocks")
+
+	b := blocks.NewBlock([]byte("aged block"))
+	if _, err := ager.StoredAt(b.Key()); err != bstore.ErrNotFound {
+		t.Fatalf("expected ErrNotFound for a missing block, got %v", err)
+	}
+
+	bs := bstore.NewBlockstore(r.Datastore())
+	assert.Nil(bs.Put(b), t, "Put should be successful")
+	stored, err := ager.StoredAt(b.Key())
+	assert.Nil(err, t, "should tell when the block was stored")
+	if d := time.Since(stored); d < 0 || d > time.Minute {
+		t.Fatalf("block was just stored, but reported as stored %s ago", d)
+	}
+
+	old := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	assert.Nil(os.Chtimes(r.ager.fs.Path(b.Key().DsKey()), old, old), t, "should find the block's file")
+	stored, err = ager.StoredAt(b.Key())
+	assert.Nil(err, t, "should tell when the block was stored")
+	if !stored.Equal(old) {
+		t.Fatalf("expected the block to be stored at %s, got %s", old, stored)
+	}
+}
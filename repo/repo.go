@@ -5,6 +5,7 @@ import (
 	"io"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/ipfs/go-datastore"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
 	config "github.com/ipfs/go-ipfs/repo/config"
 )
 
@@ -34,3 +35,11 @@ type Datastore interface {
 	ds.Batching // should be threadsafe, just be careful
 	io.Closer
 }
+
+// AgingRepo is implemented by repos that may be able to tell when the blocks
+// in their datastore were stored.
+type AgingRepo interface {
+	// BlockAger returns what tells when the repo's blocks were stored, or
+	// nil if its datastore can't.
+	BlockAger() bstore.Ager
+}
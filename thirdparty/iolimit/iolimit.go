@@ -0,0 +1,45 @@
+// Package iolimit bounds how many operations run at once across all the
+// components sharing a Limiter, such as the datastore writes of adds and the
+// deletes of GC.
+package iolimit
+
+import (
+	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+// Limiter lets at most a fixed number of operations run at once. A nil
+// Limiter lets any number run.
+type Limiter struct {
+	slots chan struct{}
+}
+
+// New returns a Limiter letting n operations run at once, or nil, for no
+// limit, if n is not positive.
+func New(n int) *Limiter {
+	if n <= 0 {
+		return nil
+	}
+	return &Limiter{slots: make(chan struct{}, n)}
+}
+
+// Acquire waits until an operation may run, or ctx is done. Each successful
+// Acquire must be followed by a Release once the operation completed.
+func (l *Limiter) Acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release lets another operation run.
+func (l *Limiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
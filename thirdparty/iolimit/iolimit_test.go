@@ -0,0 +1,42 @@
+package iolimit
+
+import (
+	"testing"
+	"time"
+
+	context "gx/ipfs/QmZy2y8t9zQH2a1b8q2ZSLKp17ATuJoCNxxyMFG5qFExpt/go-net/context"
+)
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := New(2)
+	for i := 0; i < 2; i++ {
+		if err := l.Acquire(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := l.Acquire(tctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the third Acquire to time out, got %v", err)
+	}
+
+	l.Release()
+	if err := l.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	l := New(0)
+	if l != nil {
+		t.Fatal("expected no limiter for a limit of 0")
+	}
+	for i := 0; i < 100; i++ {
+		if err := l.Acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Release()
+}